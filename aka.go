@@ -1,110 +1,168 @@
-package main
+package imdb
 
 import (
-	"bytes"
 	"fmt"
 	"io"
-	"os"
 	"path"
-	"sort"
+	"strconv"
 
 	"github.com/couchbase/vellum"
-	"github.com/jbpratt78/imdb-index/internal/types"
+	"github.com/jbpratt78/imdb-index/types"
 )
 
-const AKAS = "akas.fst"
+const (
+	AKAS       = "akas.fst"
+	AKARECORDS = "akas.records"
+)
 
+// AkasIndex maps IMDb identifiers to their alternate names, held in a store
+// of the akas columns kept in the index directory
 type AkasIndex struct {
-	idx *vellum.FST
-	sr  *io.SectionReader
+	idx  *vellum.FST
+	data *recordStore
 }
 
-func AkasOpen(indexDir, dataDir string) (*AkasIndex, error) {
+// akasOpen opens the files of its index in indexDir, which Open checks
+// against the manifest first
+func akasOpen(indexDir string) (*AkasIndex, error) {
 	idx, err := fstSetFile(path.Join(indexDir, AKAS))
 	if err != nil {
 		return nil, err
 	}
-	sr, err := mmapReader(path.Join(dataDir, IMDBAKAS))
+	data, err := openRecordStore(path.Join(indexDir, AKARECORDS))
 	if err != nil {
+		idx.Close()
 		return nil, err
 	}
 
-	return &AkasIndex{idx, sr}, nil
+	return &AkasIndex{idx, data}, nil
+}
+
+// Close releases the FST and the record store
+func (a *AkasIndex) Close() error {
+	return closeAll(a.idx, a.data)
 }
 
-func AkasCreate(dataDir, indexDir string) (*AkasIndex, error) {
+func akasCreate(src *dataSource, indexDir string) (*AkasIndex, error) {
+	t := src.t
 	fstAkasFile := path.Join(indexDir, AKAS)
-	tsv, err := os.Open(path.Join(dataDir, IMDBAKAS))
+	tsv, err := src.open(IMDBAKAS)
 	if err != nil {
 		return nil, err
 	}
 	defer tsv.Close()
 
+	records, err := createRecordStore(path.Join(indexDir, AKARECORDS))
+	if err != nil {
+		return nil, err
+	}
+	defer records.Close()
+
 	akasBuilder, akasIndexFile, err := fstSetBuilderFile(fstAkasFile)
 	if err != nil {
 		return nil, err
 	}
 
-	akas, err := readSortedAkas(tsv)
+	akas, err := readSortedAkas(tsv, records, t.start(IMDBAKAS, StageParse, 0))
 	if err != nil {
 		return nil, fmt.Errorf("failed to read akas tsv: %w", err)
 	}
+	if err = records.Close(); err != nil {
+		return nil, err
+	}
 
+	p := t.start(AKAS, StageInsert, int64(len(akas)))
 	for _, aka := range akas {
-		if err = akasBuilder.Insert([]byte(aka.Id), (aka.Count<<48)|aka.Offset); err != nil {
+		val, err := akaValue(aka)
+		if err != nil {
+			return nil, err
+		}
+		if err = akasBuilder.Insert([]byte(aka.Id), val); err != nil {
 			return nil, fmt.Errorf("failed to insert aka: %v %w", aka, err)
 		}
+		if err = p.add(1); err != nil {
+			return nil, err
+		}
+	}
+	p.finish()
+
+	if err = akasBuilder.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close akas builder: %w", err)
+	}
+	if err = akasIndexFile.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close akas index file: %w", err)
 	}
 
-	akasIndexFile.Close()
+	return akasOpen(indexDir)
+}
 
-	return AkasOpen(indexDir, dataDir)
+// akaValue packs the number of rows of a title's alternate names into the
+// top 16 bits of its FST value and the address of the first row into the
+// other 48
+func akaValue(aka *types.Aka) (uint64, error) {
+	if aka.Count >= 1<<16 || aka.Offset >= 1<<48 {
+		return 0, fmt.Errorf("alternate names of %q do not fit an index value: %d rows at address %x", aka.Id, aka.Count, aka.Offset)
+	}
+	return aka.Count<<48 | aka.Offset, nil
 }
 
+// Find returns every alternate name of the title with the given id
 func (a *AkasIndex) Find(id []uint8) ([]*types.Aka, error) {
-	v, valid, err := a.idx.Get(id)
+	akas, ok, err := a.Lookup(id)
 	if err != nil {
 		return nil, err
 	}
-	if !valid {
-		return nil, fmt.Errorf("failed to find %q", id)
+	if !ok {
+		return nil, fmt.Errorf("alternate names of %q: %w", id, ErrNotFound)
 	}
+	return akas, nil
+}
 
-	count := v >> 48
-	offset := v & ((1 << 48) - 1)
+// Lookup returns the alternate names of the given id and whether it has any
+func (a *AkasIndex) Lookup(id []uint8) ([]*types.Aka, bool, error) {
+	v, valid, err := a.idx.Get(id)
+	if err != nil || !valid {
+		return nil, false, err
+	}
 
-	if _, err := a.sr.Seek(int64(offset), io.SeekStart); err != nil {
-		return nil, err
+	count := int(v >> 48)
+	addr := v & ((1 << 48) - 1)
+
+	recs, err := a.data.read(addr, count)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read akas of %q: %v: %w", id, err, ErrCorruptIndex)
 	}
 
-	csvr := csvRBuilder(a.sr)
-	for i := 0; i < int(count); i++ {
-		_, err := csvr.Read()
+	akas := make([]*types.Aka, 0, count)
+	for _, rec := range recs {
+		aka, err := readAka(rec)
 		if err != nil {
-			return nil, err
+			return nil, false, fmt.Errorf("%v: %w", err, ErrCorruptIndex)
+		}
+		if aka.Id != string(id) {
+			return nil, false, fmt.Errorf("aka record at address %x belongs to %q, not %q: %w", addr, aka.Id, id, ErrCorruptIndex)
 		}
+		akas = append(akas, aka)
 	}
-
-	return nil, fmt.Errorf("unimplemented")
+	return akas, true, nil
 }
 
-func readSortedAkas(in io.Reader) ([]*types.Aka, error) {
-	var count uint64 = 0
-	var buf bytes.Buffer
-	var offset uint64
-
+// readSortedAkas reads the akas TSV, which must be sorted by title id, adds
+// every row to records and returns one record per title holding the address
+// of its first row and the number of rows that follow it
+func readSortedAkas(in io.Reader, records *recordWriter, p *progress) ([]*types.Aka, error) {
 	header := []string{}
 	akas := []*types.Aka{}
-	tr := io.TeeReader(in, &buf)
-	csvReader := csvRBuilder(tr)
+	csvReader := csvRBuilder(in)
 
+	var last *types.Aka
 	for {
 		rec, err := csvReader.Read()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			panic(err)
+			return nil, fmt.Errorf("failed to read csv: %w", err)
 		}
 
 		if len(header) == 0 {
@@ -112,21 +170,47 @@ func readSortedAkas(in io.Reader) ([]*types.Aka, error) {
 			continue
 		}
 
-		line, err := buf.ReadBytes('\n')
+		offset, err := records.add(rec)
 		if err != nil {
-			panic(err)
+			return nil, err
+		}
+		if err = p.add(1); err != nil {
+			return nil, err
 		}
 
-		length := len(line)
-		offset += uint64(length)
+		if last != nil && last.Id == rec[0] {
+			last.Count++
+			continue
+		}
+		if last != nil && last.Id > rec[0] {
+			return nil, fmt.Errorf("akas are not sorted: %q follows %q", rec[0], last.Id)
+		}
 
-		aka := &types.Aka{Id: rec[0], Offset: offset, Count: count}
-		akas = append(akas, aka)
-		count += aka.Count
+		last = &types.Aka{Id: rec[0], Offset: offset, Count: 1}
+		akas = append(akas, last)
 	}
-
-	sort.Slice(akas, func(i, j int) bool {
-		return akas[i].Id < akas[j].Id
-	})
+	p.finish()
 	return akas, nil
 }
+
+func readAka(rec []string) (*types.Aka, error) {
+	if len(rec) != 8 {
+		return nil, fmt.Errorf("expected 8 columns in aka record, got %d: %v", len(rec), rec)
+	}
+
+	order, err := strconv.ParseInt(rec[1], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ordering for %v got %w", rec, err)
+	}
+
+	return &types.Aka{
+		Id:              rec[0],
+		Order:           int32(order),
+		Title:           rec[2],
+		Region:          optionalString(rec[3]),
+		Language:        optionalString(rec[4]),
+		Types:           optionalString(rec[5]),
+		Attributes:      optionalString(rec[6]),
+		IsOriginalTitle: rec[7] == "1",
+	}, nil
+}
@@ -1 +1,102 @@
-package main
+package imdb
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/jbpratt78/imdb-index/types"
+)
+
+// index gets setup in episode_test.go:TestMain
+func TestAkasFind(t *testing.T) {
+	idx, err := akasOpen(tmpDir)
+	if err != nil {
+		t.Fatalf("failed to open akas index: %v", err)
+	}
+
+	akas, err := idx.Find([]byte("tt0096697"))
+	if err != nil {
+		t.Fatalf("failed to find akas: %v", err)
+	}
+
+	if len(akas) != 38 {
+		t.Fatalf("got the wrong amount of akas: got=%d want=%d", len(akas), 38)
+	}
+
+	var original int
+	for _, aka := range akas {
+		if aka.Id != "tt0096697" {
+			t.Fatalf("incorrect id: %q", aka.Id)
+		}
+		if aka.IsOriginalTitle {
+			original++
+			if aka.Order != 2 || aka.Title != "The Simpsons" || aka.Types != "original" {
+				t.Fatalf("incorrect original title: %+v", aka)
+			}
+		}
+		if aka.Order == 30 {
+			if aka.Region != "CA" || aka.Language != "fr" || aka.Attributes != "dubbed version" {
+				t.Fatalf("incorrect aka: %+v", aka)
+			}
+		}
+	}
+	if original != 1 {
+		t.Fatalf("got the wrong amount of original titles: got=%d want=%d", original, 1)
+	}
+}
+
+func TestAkasReadSorted(t *testing.T) {
+	header := "titleId\tordering\ttitle\tregion\tlanguage\ttypes\tattributes\tisOriginalTitle\n"
+	tsv := header +
+		"tt01\t1\ta\t\\N\t\\N\t\\N\t\\N\t1\n" +
+		"tt01\t2\tb\t\\N\t\\N\t\\N\t\\N\t0\n" +
+		"tt02\t1\tc\t\\N\t\\N\t\\N\t\\N\t1\n"
+
+	var buf bytes.Buffer
+	records, err := newRecordWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	akas, err := readSortedAkas(strings.NewReader(tsv), records, nil)
+	if err != nil {
+		t.Fatalf("failed to read akas: %v", err)
+	}
+	if err = records.Close(); err != nil {
+		t.Fatalf("failed to close records: %v", err)
+	}
+
+	if len(akas) != 2 {
+		t.Fatalf("got the wrong amount of titles: got=%d want=%d", len(akas), 2)
+	}
+	if akas[0].Count != 2 || akas[1].Count != 1 {
+		t.Fatalf("incorrect counts: %+v %+v", akas[0], akas[1])
+	}
+
+	store, err := newRecordStore(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("failed to open records: %v", err)
+	}
+	recs, err := store.read(akas[1].Offset, int(akas[1].Count))
+	if err != nil {
+		t.Fatalf("failed to read records: %v", err)
+	}
+	if recs[0][0] != "tt02" || recs[0][2] != "c" {
+		t.Fatalf("incorrect record of the second title: %v", recs[0])
+	}
+}
+
+func TestAkaValue(t *testing.T) {
+	val, err := akaValue(&types.Aka{Id: "tt0000001", Count: 3, Offset: 42})
+	if err != nil || val>>48 != 3 || val&(1<<48-1) != 42 {
+		t.Fatalf("incorrect value %x: %v", val, err)
+	}
+	for _, aka := range []*types.Aka{
+		{Id: "tt0000001", Count: 1 << 16, Offset: 0},
+		{Id: "tt0000001", Count: 1, Offset: 1 << 48},
+	} {
+		if _, err := akaValue(aka); err == nil {
+			t.Fatalf("expected error for %d rows at offset %d", aka.Count, aka.Offset)
+		}
+	}
+}
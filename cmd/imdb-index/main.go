@@ -0,0 +1,566 @@
+// Command imdb-index downloads the IMDb datasets, builds indices over them
+// and queries those indices from the command line.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	imdb "github.com/jbpratt78/imdb-index"
+	"github.com/jbpratt78/imdb-index/types"
+)
+
+// usageError is returned for bad command lines, which exit with status 2
+type usageError string
+
+func (e usageError) Error() string { return string(e) }
+
+type command struct {
+	name  string
+	args  string
+	short string
+	run   func(c *cli, fs *flag.FlagSet, args []string) error
+}
+
+var commands = []*command{
+	{"download", "", "download and sort the IMDb datasets into the data dir", runDownload},
+	{"build", "", "build every index from the data dir into the index dir", runBuild},
+	{"verify", "", "check every file of the index for inconsistencies", runVerify},
+	{"search", "<query>", "search titles, e.g. `the simpsons {show} {year:1989-}`", runSearch},
+	{"title", "<id>...", "print title records", runTitle},
+	{"rating", "<id>...", "print rating records", runRating},
+	{"top", "", "print the top rated titles, e.g. `--min 8 --min-votes 10000 --kind movie`", runTop},
+	{"episodes", "<show-id>", "print the episodes of a TV show", runEpisodes},
+	{"akas", "<id>", "print the alternate names of a title", runAkas},
+	{"serve", "", "serve the index as a JSON API over HTTP", runServe},
+}
+
+// cli holds the options shared by every command
+type cli struct {
+	ctx      context.Context
+	stdout   io.Writer
+	stderr   io.Writer
+	dataDir  string
+	indexDir string
+	json     bool
+}
+
+func main() {
+	// an interrupt stops downloads, builds and the server cleanly
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		<-interrupts
+		cancel()
+		// a second interrupt kills the process as usual
+		signal.Stop(interrupts)
+	}()
+	err := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+	cancel()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "imdb-index: %v\n", err)
+		var uerr usageError
+		if errors.As(err, &uerr) {
+			os.Exit(2)
+		}
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		usage(stderr)
+		return usageError("missing command")
+	}
+
+	for _, cmd := range commands {
+		if cmd.name != args[0] {
+			continue
+		}
+
+		c := &cli{ctx: ctx, stdout: stdout, stderr: stderr}
+		fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+		fs.SetOutput(stderr)
+		fs.StringVar(&c.dataDir, "data-dir", "data", "directory of the IMDb .tsv or .tsv.gz files, used by download and build")
+		fs.StringVar(&c.indexDir, "index-dir", "index", "directory of the index files")
+		fs.BoolVar(&c.json, "json", false, "print JSON instead of tables")
+		fs.Usage = func() {
+			fmt.Fprintf(stderr, "usage: imdb-index %s [flags] %s\n\n%s\n\nflags:\n", cmd.name, cmd.args, cmd.short)
+			fs.PrintDefaults()
+		}
+		return cmd.run(c, fs, args[1:])
+	}
+
+	if args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		usage(stdout)
+		return nil
+	}
+	usage(stderr)
+	return usageError(fmt.Sprintf("unknown command %q", args[0]))
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "usage: imdb-index <command> [flags] [args]\n\ncommands:\n")
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(tw, "  %s %s\t%s\n", cmd.name, cmd.args, cmd.short)
+	}
+	tw.Flush()
+}
+
+// parse parses the flags of a command and checks the number of positional
+// arguments is within [min, max], where a negative max is unbounded
+func parse(fs *flag.FlagSet, args []string, min, max int) ([]string, error) {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, err
+		}
+		return nil, usageError(err.Error())
+	}
+	rest := fs.Args()
+	if len(rest) < min || (max >= 0 && len(rest) > max) {
+		fs.Usage()
+		return nil, usageError(fmt.Sprintf("%s: wrong number of arguments", fs.Name()))
+	}
+	return rest, nil
+}
+
+func runDownload(c *cli, fs *flag.FlagSet, args []string) error {
+	sortMemory := fs.Int("sort-memory", imdb.DefaultSortOptions.MemoryBudget>>20, "megabytes of lines to sort in memory before spilling to disk")
+	tmpDir := fs.String("tmp-dir", "", "directory for sorted runs spilled to disk")
+	source := fs.String("source", imdb.IMDBBaseURL, "base URL, file:// URL or local directory holding the .tsv.gz datasets")
+	retries := fs.Int("retries", imdb.NewDownloader().Retries, "times a failed download is retried")
+	compressed := fs.Bool("compressed", false, "keep only the .tsv.gz datasets, which build sorts as it reads them")
+	progress := fs.Bool("progress", true, "report progress on stderr")
+	if _, err := parse(fs, args, 0, 0); err != nil {
+		return helpOK(err)
+	}
+
+	d := imdb.NewDownloader()
+	d.Source = *source
+	d.Retries = *retries
+	d.CompressedOnly = *compressed
+	d.Sort = imdb.SortOptions{MemoryBudget: *sortMemory << 20, TempDir: *tmpDir}
+	if *progress {
+		display := newProgressDisplay(c.stderr)
+		defer display.close()
+		d.Progress = display.report
+	}
+	changed, err := d.DownloadAllContext(c.ctx, c.dataDir)
+	if err != nil {
+		return fmt.Errorf("failed to download datasets: %w", err)
+	}
+	if len(changed) == 0 {
+		fmt.Fprintf(c.stdout, "datasets in %s are up to date\n", c.dataDir)
+		return nil
+	}
+	for _, dataset := range changed {
+		fmt.Fprintf(c.stdout, "downloaded %s to %s\n", dataset, c.dataDir)
+	}
+	return nil
+}
+
+func runBuild(c *cli, fs *flag.FlagSet, args []string) error {
+	ngramType := fs.String("ngram-type", string(imdb.DefaultNameConfig.NgramType), "name ngram type, window or edge")
+	ngramSize := fs.Int("ngram-size", imdb.DefaultNameConfig.NgramSize, "name ngram size")
+	sortMemory := fs.Int("sort-memory", imdb.DefaultSortOptions.MemoryBudget>>20, "megabytes of lines to sort in memory per .tsv.gz dataset before spilling to disk")
+	tmpDir := fs.String("tmp-dir", "", "directory for sorted runs spilled to disk")
+	progress := fs.Bool("progress", true, "report progress on stderr")
+	if _, err := parse(fs, args, 0, 0); err != nil {
+		return helpOK(err)
+	}
+
+	t, err := imdb.ParseNgramType(*ngramType)
+	if err != nil {
+		return usageError(err.Error())
+	}
+	opts := imdb.BuildOptions{
+		Names: imdb.NameConfig{NgramType: t, NgramSize: *ngramSize},
+		Sort:  imdb.SortOptions{MemoryBudget: *sortMemory << 20, TempDir: *tmpDir},
+	}
+	if *progress {
+		display := newProgressDisplay(c.stderr)
+		defer display.close()
+		opts.Progress = display.report
+	}
+
+	idx, err := imdb.CreateContext(c.ctx, c.dataDir, c.indexDir, opts)
+	if err != nil {
+		return err
+	}
+	if err = idx.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stdout, "built index in %s\n", c.indexDir)
+	return nil
+}
+
+func runVerify(c *cli, fs *flag.FlagSet, args []string) error {
+	if _, err := parse(fs, args, 0, 0); err != nil {
+		return helpOK(err)
+	}
+
+	problems, err := imdb.Verify(c.ctx, c.indexDir)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		if err = c.printJSON(problems); err != nil {
+			return err
+		}
+	} else if len(problems) > 0 {
+		err = c.printTable([]string{"FILE", "KEY", "PROBLEM"}, len(problems), func(i int) []string {
+			p := problems[i]
+			return []string{p.File, p.Key, p.Message}
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("index %s has %d problems", c.indexDir, len(problems))
+	}
+	if !c.json {
+		fmt.Fprintf(c.stdout, "index %s has no problems\n", c.indexDir)
+	}
+	return nil
+}
+
+func runSearch(c *cli, fs *flag.FlagSet, args []string) error {
+	rest, err := parse(fs, args, 1, -1)
+	if err != nil {
+		return helpOK(err)
+	}
+
+	q, err := imdb.ParseQuery(strings.Join(rest, " "))
+	if err != nil {
+		return usageError(err.Error())
+	}
+
+	idx, err := imdb.Open(c.indexDir)
+	if err != nil {
+		return err
+	}
+	defer idx.Close()
+
+	results, err := idx.Searcher().Search(q)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(results)
+	}
+
+	return c.printTable([]string{"SCORE", "ID", "KIND", "YEAR", "RATING", "VOTES", "EPISODE", "TITLE"}, len(results), func(i int) []string {
+		r := results[i]
+		row := []string{fmt.Sprintf("%.3f", r.Score)}
+		row = append(row, r.Title.Id, string(r.Title.Kind), optionalNumber(r.Title.StartYear))
+		row = append(row, ratingColumns(r.Rating)...)
+		return append(row, episodeColumn(r.Episode), r.Title.Title)
+	})
+}
+
+func runTitle(c *cli, fs *flag.FlagSet, args []string) error {
+	ids, err := parse(fs, args, 1, -1)
+	if err != nil {
+		return helpOK(err)
+	}
+
+	idx, err := imdb.Open(c.indexDir)
+	if err != nil {
+		return err
+	}
+	defer idx.Close()
+
+	titles := make([]*types.Title, 0, len(ids))
+	for _, id := range ids {
+		t, err := idx.Titles().Title([]byte(id))
+		if err != nil {
+			return err
+		}
+		titles = append(titles, t)
+	}
+	if c.json {
+		return c.printJSON(titles)
+	}
+
+	return c.printTable([]string{"ID", "KIND", "YEAR", "END", "RUNTIME", "ADULT", "GENRES", "TITLE", "ORIGINAL"}, len(titles), func(i int) []string {
+		t := titles[i]
+		return []string{
+			t.Id, string(t.Kind), optionalNumber(t.StartYear), optionalNumber(t.EndYear),
+			optionalNumber(t.RuntimeMinutes), strconv.FormatBool(t.IsAdult), t.Genres,
+			t.Title, t.OriginalTitle,
+		}
+	})
+}
+
+func runRating(c *cli, fs *flag.FlagSet, args []string) error {
+	ids, err := parse(fs, args, 1, -1)
+	if err != nil {
+		return helpOK(err)
+	}
+
+	idx, err := imdb.Open(c.indexDir)
+	if err != nil {
+		return err
+	}
+	defer idx.Close()
+
+	ratings := make([]*types.Rating, 0, len(ids))
+	for _, id := range ids {
+		r, err := idx.Ratings().Rating([]byte(id))
+		if err != nil {
+			return err
+		}
+		ratings = append(ratings, r)
+	}
+	if c.json {
+		return c.printJSON(ratings)
+	}
+
+	return c.printTable([]string{"ID", "RATING", "VOTES"}, len(ratings), func(i int) []string {
+		return append([]string{ratings[i].Id}, ratingColumns(ratings[i])...)
+	})
+}
+
+func runTop(c *cli, fs *flag.FlagSet, args []string) error {
+	min := fs.Float64("min", 0, "lowest rating")
+	max := fs.Float64("max", 0, "highest rating, 0 for no bound")
+	minVotes := fs.Uint("min-votes", 0, "least number of votes")
+	kind := fs.String("kind", "", "comma separated kinds of titles, e.g. `movie,show`")
+	limit := fs.Int("limit", 100, "most titles to print, 0 for all")
+	if _, err := parse(fs, args, 0, 0); err != nil {
+		return helpOK(err)
+	}
+
+	q := imdb.RatingQuery{MinRating: float32(*min), MaxRating: float32(*max), MinVotes: uint32(*minVotes), Limit: *limit}
+	if *kind != "" {
+		for _, name := range strings.Split(*kind, ",") {
+			kinds, ok := imdb.ParseKinds(name)
+			if !ok {
+				return usageError(fmt.Sprintf("unknown kind %q", name))
+			}
+			q.Kinds = append(q.Kinds, kinds...)
+		}
+	}
+
+	idx, err := imdb.Open(c.indexDir)
+	if err != nil {
+		return err
+	}
+	defer idx.Close()
+
+	rated, err := idx.TopRated(q)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(rated)
+	}
+
+	return c.printTable([]string{"ID", "KIND", "YEAR", "RATING", "VOTES", "TITLE"}, len(rated), func(i int) []string {
+		r := rated[i]
+		row := []string{r.Title.Id, string(r.Title.Kind), optionalNumber(r.Title.StartYear)}
+		row = append(row, ratingColumns(r.Rating)...)
+		return append(row, r.Title.Title)
+	})
+}
+
+func runEpisodes(c *cli, fs *flag.FlagSet, args []string) error {
+	season := fs.Int("season", -1, "only print episodes of this season, 0 for the specials")
+	unnumbered := fs.Bool("unnumbered", false, "only print episodes without a season or episode number")
+	rest, err := parse(fs, args, 1, 1)
+	if err != nil {
+		return helpOK(err)
+	}
+
+	idx, err := imdb.Open(c.indexDir)
+	if err != nil {
+		return err
+	}
+	defer idx.Close()
+
+	var eps []*types.Episode
+	switch {
+	case *unnumbered:
+		eps, err = idx.Episodes().Unnumbered([]byte(rest[0]))
+	case *season >= 0:
+		eps, err = idx.Episodes().Episodes([]byte(rest[0]), uint32(*season))
+	default:
+		eps, err = idx.Episodes().ShowEpisodes([]byte(rest[0]))
+	}
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(eps)
+	}
+
+	return c.printTable([]string{"ID", "SHOW", "SEASON", "EPISODE"}, len(eps), func(i int) []string {
+		e := eps[i]
+		return []string{e.Id, e.TvShowID, episodeNumber(e.Season), episodeNumber(e.Episode)}
+	})
+}
+
+func runAkas(c *cli, fs *flag.FlagSet, args []string) error {
+	rest, err := parse(fs, args, 1, 1)
+	if err != nil {
+		return helpOK(err)
+	}
+
+	idx, err := imdb.Open(c.indexDir)
+	if err != nil {
+		return err
+	}
+	defer idx.Close()
+
+	akas, err := idx.Akas().Find([]byte(rest[0]))
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(akas)
+	}
+
+	return c.printTable([]string{"ORDER", "REGION", "LANGUAGE", "TYPES", "ATTRIBUTES", "ORIGINAL", "TITLE"}, len(akas), func(i int) []string {
+		a := akas[i]
+		return []string{
+			strconv.Itoa(int(a.Order)), a.Region, a.Language, a.Types, a.Attributes,
+			strconv.FormatBool(a.IsOriginalTitle), a.Title,
+		}
+	})
+}
+
+func runServe(c *cli, fs *flag.FlagSet, args []string) error {
+	addr := fs.String("addr", ":8080", "address to listen on")
+	reload := fs.Duration("reload", time.Minute, "how often to check the index for a rebuild, 0 to never reload")
+	if _, err := parse(fs, args, 0, 0); err != nil {
+		return helpOK(err)
+	}
+
+	h, err := imdb.HolderOpen(c.indexDir)
+	if err != nil {
+		return err
+	}
+	defer h.Close()
+
+	srv := &http.Server{
+		Addr:         *addr,
+		Handler:      imdb.NewHolderServer(h),
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+	fmt.Fprintf(c.stderr, "serving %s on %s\n", c.indexDir, *addr)
+
+	watchCtx, stopWatch := context.WithCancel(c.ctx)
+	watched := make(chan struct{})
+	go func() {
+		defer close(watched)
+		if *reload <= 0 {
+			return
+		}
+		h.Watch(watchCtx, *reload, func(m *imdb.Manifest, err error) {
+			if err != nil {
+				fmt.Fprintf(c.stderr, "failed to reload %s: %v\n", c.indexDir, err)
+				return
+			}
+			fmt.Fprintf(c.stderr, "reloaded %s built at %s\n", c.indexDir, m.BuiltAt.Format(time.RFC3339))
+		})
+	}()
+	// the holder is closed once the watcher stopped
+	defer func() {
+		stopWatch()
+		<-watched
+	}()
+
+	errc := make(chan error, 1)
+	go func() { errc <- srv.ListenAndServe() }()
+	select {
+	case err := <-errc:
+		return err
+	case <-c.ctx.Done():
+	}
+
+	// let requests in flight finish before the index is closed
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return srv.Shutdown(ctx)
+}
+
+// helpOK swallows the error of an explicit -h, which is not a failure
+func helpOK(err error) error {
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	return err
+}
+
+func (c *cli) printJSON(v interface{}) error {
+	// no results are an empty list, not null
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Slice && rv.IsNil() {
+		v = []struct{}{}
+	}
+	enc := json.NewEncoder(c.stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func (c *cli) printTable(header []string, n int, row func(i int) []string) error {
+	tw := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for i := 0; i < n; i++ {
+		fmt.Fprintln(tw, strings.Join(row(i), "\t"))
+	}
+	return tw.Flush()
+}
+
+func optionalNumber(n uint32) string {
+	if n == 0 {
+		return "-"
+	}
+	return strconv.FormatUint(uint64(n), 10)
+}
+
+func ratingColumns(r *types.Rating) []string {
+	if r == nil {
+		return []string{"-", "-"}
+	}
+	return []string{strconv.FormatFloat(float64(r.Rating), 'f', 1, 32), strconv.FormatUint(uint64(r.Votes), 10)}
+}
+
+func episodeColumn(e *types.Episode) string {
+	if e == nil {
+		return "-"
+	}
+	season, episode := "??", "??"
+	if e.Season != types.UnknownNumber {
+		season = fmt.Sprintf("%02d", e.Season)
+	}
+	if e.Episode != types.UnknownNumber {
+		episode = fmt.Sprintf("%02d", e.Episode)
+	}
+	return "S" + season + "E" + episode
+}
+
+// episodeNumber formats a season or episode number, which unlike other
+// numbers may be 0
+func episodeNumber(n uint32) string {
+	if n == types.UnknownNumber {
+		return "-"
+	}
+	return strconv.FormatUint(uint64(n), 10)
+}
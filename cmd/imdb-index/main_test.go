@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	imdb "github.com/jbpratt78/imdb-index"
+)
+
+const testdata = "../../testdata"
+
+func TestCLIBuildAndSearch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imdb-index-cli")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	dir = path.Join(dir, "index")
+
+	var stdout, stderr bytes.Buffer
+	if err := run(context.Background(), []string{"build", "--data-dir", testdata, "--index-dir", dir}, &stdout, &stderr); err != nil {
+		t.Fatalf("failed to build: %v: %s", err, stderr.String())
+	}
+
+	if !strings.Contains(stderr.String(), imdb.TITLES+" insert:") {
+		t.Fatalf("expected build progress, got %q", stderr.String())
+	}
+
+	stdout.Reset()
+	err = run(context.Background(), []string{"search", "--index-dir", dir, "--json", "simpsns", "{show}"}, &stdout, &stderr)
+	if err != nil {
+		t.Fatalf("failed to search: %v: %s", err, stderr.String())
+	}
+	var results []*imdb.SearchResult
+	if err := json.Unmarshal(stdout.Bytes(), &results); err != nil {
+		t.Fatalf("failed to decode results: %v", err)
+	}
+	if len(results) != 1 || results[0].Title.Id != "tt0096697" {
+		t.Fatalf("incorrect results: %s", stdout.String())
+	}
+
+	// no results are an empty list for every command
+	for _, args := range [][]string{
+		{"search", "--index-dir", dir, "--json", "zzzzzz"},
+		{"episodes", "--index-dir", dir, "--json", "tt9999999"},
+		{"top", "--index-dir", dir, "--json", "--min", "9.9"},
+	} {
+		stdout.Reset()
+		if err := run(context.Background(), args, &stdout, &stderr); err != nil {
+			t.Fatalf("failed to run %s: %v", args[0], err)
+		}
+		if got := strings.TrimSpace(stdout.String()); got != "[]" {
+			t.Fatalf("expected an empty list from %s, got %q", args[0], got)
+		}
+	}
+
+	stdout.Reset()
+	err = run(context.Background(), []string{"title", "--index-dir", dir, "tt0701063"}, &stdout, &stderr)
+	if err != nil {
+		t.Fatalf("failed to print title: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "Bart's Dog Gets an F") {
+		t.Fatalf("incorrect title table: %s", stdout.String())
+	}
+
+	err = run(context.Background(), []string{"rating", "--index-dir", dir, "tt9999999"}, &stdout, &stderr)
+	if err == nil {
+		t.Fatalf("expected error for missing rating")
+	}
+
+	stdout.Reset()
+	err = run(context.Background(), []string{"top", "--index-dir", dir, "--json", "--kind", "episode", "--min", "7.5"}, &stdout, &stderr)
+	if err != nil {
+		t.Fatalf("failed to print top rated: %v", err)
+	}
+	var rated []*imdb.RatedTitle
+	if err := json.Unmarshal(stdout.Bytes(), &rated); err != nil {
+		t.Fatalf("failed to decode top rated: %v", err)
+	}
+	if len(rated) != 1 || rated[0].Title.Id != "tt0701062" {
+		t.Fatalf("incorrect top rated: %s", stdout.String())
+	}
+
+	stdout.Reset()
+	err = run(context.Background(), []string{"verify", "--index-dir", dir}, &stdout, &stderr)
+	if err != nil {
+		t.Fatalf("failed to verify: %v: %s", err, stdout.String())
+	}
+
+	// a truncated FST is reported as a problem
+	if err = os.Truncate(path.Join(dir, imdb.RATINGS), 10); err != nil {
+		t.Fatal(err)
+	}
+	stdout.Reset()
+	err = run(context.Background(), []string{"verify", "--index-dir", dir, "--json"}, &stdout, &stderr)
+	if err == nil {
+		t.Fatalf("expected error for truncated ratings")
+	}
+	var problems []imdb.Problem
+	if err := json.Unmarshal(stdout.Bytes(), &problems); err != nil || len(problems) == 0 {
+		t.Fatalf("incorrect problems: %v: %s", err, stdout.String())
+	}
+}
+
+func TestCLIDownloadFromDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imdb-index-cli")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	mirror := path.Join(dir, "mirror")
+	os.Mkdir(mirror, os.ModePerm)
+	for _, dataset := range []string{imdb.IMDBAKAS, imdb.IMDBBasics, imdb.IMDBEpisode, imdb.IMDBRatings} {
+		data, err := ioutil.ReadFile(path.Join(testdata, dataset))
+		if err != nil {
+			t.Fatalf("failed to read dataset: %v", err)
+		}
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		w.Write(data)
+		w.Close()
+		if err := ioutil.WriteFile(path.Join(mirror, dataset+".gz"), buf.Bytes(), 0644); err != nil {
+			t.Fatalf("failed to write mirror: %v", err)
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	dataDir := path.Join(dir, "data")
+	err = run(context.Background(), []string{"download", "--data-dir", dataDir, "--source", mirror}, &stdout, &stderr)
+	if err != nil {
+		t.Fatalf("failed to download: %v: %s", err, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "downloaded "+imdb.IMDBBasics) {
+		t.Fatalf("incorrect output: %s", stdout.String())
+	}
+
+	stdout.Reset()
+	err = run(context.Background(), []string{"download", "--data-dir", dataDir, "--source", mirror}, &stdout, &stderr)
+	if err != nil {
+		t.Fatalf("failed to download: %v: %s", err, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "up to date") {
+		t.Fatalf("expected the datasets to be up to date: %s", stdout.String())
+	}
+}
+
+func TestCLIUsage(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	var uerr usageError
+
+	if err := run(context.Background(), nil, &stdout, &stderr); !errors.As(err, &uerr) {
+		t.Fatalf("expected usage error, got %v", err)
+	}
+	if err := run(context.Background(), []string{"frobnicate"}, &stdout, &stderr); !errors.As(err, &uerr) {
+		t.Fatalf("expected usage error, got %v", err)
+	}
+	if err := run(context.Background(), []string{"akas"}, &stdout, &stderr); !errors.As(err, &uerr) {
+		t.Fatalf("expected usage error, got %v", err)
+	}
+	if err := run(context.Background(), []string{"search", "{bogus}"}, &stdout, &stderr); !errors.As(err, &uerr) {
+		t.Fatalf("expected usage error, got %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	imdb "github.com/jbpratt78/imdb-index"
+)
+
+// redrawInterval limits how often the progress line of a terminal is redrawn
+const redrawInterval = 100 * time.Millisecond
+
+// progressDisplay renders progress reports. Every finished stage is printed
+// on a line of its own and, on a terminal, the stages still running share a
+// status line that is redrawn in place.
+type progressDisplay struct {
+	mu      sync.Mutex
+	w       io.Writer
+	tty     bool
+	running map[string]imdb.Progress
+	order   []string
+	drawn   int
+	last    time.Time
+}
+
+func newProgressDisplay(w io.Writer) *progressDisplay {
+	return &progressDisplay{w: w, tty: isTerminal(w), running: map[string]imdb.Progress{}}
+}
+
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// report is an imdb.ProgressFunc
+func (d *progressDisplay) report(p imdb.Progress) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := p.Name + " " + string(p.Stage)
+	if p.Finished {
+		if _, ok := d.running[key]; ok {
+			delete(d.running, key)
+			for i, k := range d.order {
+				if k == key {
+					d.order = append(d.order[:i], d.order[i+1:]...)
+					break
+				}
+			}
+		}
+		d.clear()
+		fmt.Fprintf(d.w, "%s: %s\n", key, formatCount(p))
+		d.draw()
+		return
+	}
+
+	if _, ok := d.running[key]; !ok {
+		d.order = append(d.order, key)
+	}
+	d.running[key] = p
+	if time.Since(d.last) >= redrawInterval {
+		d.clear()
+		d.draw()
+	}
+}
+
+// close clears the status line
+func (d *progressDisplay) close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.clear()
+}
+
+func (d *progressDisplay) clear() {
+	if d.drawn > 0 {
+		fmt.Fprintf(d.w, "\r%s\r", strings.Repeat(" ", d.drawn))
+		d.drawn = 0
+	}
+}
+
+func (d *progressDisplay) draw() {
+	if !d.tty || len(d.order) == 0 {
+		return
+	}
+	parts := make([]string, len(d.order))
+	for i, key := range d.order {
+		parts[i] = key + " " + formatCount(d.running[key])
+	}
+	line := strings.Join(parts, " | ")
+	fmt.Fprint(d.w, line)
+	d.drawn = len(line)
+	d.last = time.Now()
+}
+
+// formatCount formats the count of a report in the unit of its stage
+func formatCount(p imdb.Progress) string {
+	unit := "rows"
+	switch p.Stage {
+	case imdb.StageDownload:
+		if p.Total > 0 {
+			return fmt.Sprintf("%s of %s (%d%%)", formatBytes(p.Count), formatBytes(p.Total), p.Count*100/p.Total)
+		}
+		return formatBytes(p.Count)
+	case imdb.StageInsert:
+		unit = "keys"
+	}
+	if p.Total > 0 {
+		return fmt.Sprintf("%d of %d %s (%d%%)", p.Count, p.Total, unit, p.Count*100/p.Total)
+	}
+	return fmt.Sprintf("%d %s", p.Count, unit)
+}
+
+func formatBytes(n int64) string {
+	const unit = 1 << 10
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
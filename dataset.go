@@ -0,0 +1,205 @@
+package imdb
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"sync"
+)
+
+// dataSource opens the datasets an index is built from and records the
+// checksum of every dataset file read to the end
+type dataSource struct {
+	dir string
+	// Sort controls the external sort of compressed datasets.
+	sort SortOptions
+	t    *tracker
+	// tmp, when set, is the directory compressed datasets are sorted into,
+	// so that builders reading the same dataset share a single sort.
+	// Otherwise every open sorts the dataset again.
+	tmp string
+
+	mu       sync.Mutex
+	datasets map[string]DatasetInfo
+	sorted   map[string]*sortedDataset
+}
+
+// sortedDataset is a compressed dataset sorted into a temporary file, which
+// is compressed again so that the datasets are never on disk uncompressed
+type sortedDataset struct {
+	once sync.Once
+	path string
+	err  error
+}
+
+func newDataSource(t *tracker, dir string, opts SortOptions) *dataSource {
+	return &dataSource{
+		dir:      dir,
+		sort:     opts,
+		t:        t,
+		datasets: map[string]DatasetInfo{},
+		sorted:   map[string]*sortedDataset{},
+	}
+}
+
+// checksummed wraps the file a dataset is read from
+func (s *dataSource) checksummed(name string, f *os.File) io.ReadCloser {
+	return &checksumReader{f: f, h: sha256.New(), done: func(sum string) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.datasets[name] = DatasetInfo{File: path.Base(f.Name()), SHA256: sum}
+	}}
+}
+
+// checksums returns the checksums of the datasets read to the end
+func (s *dataSource) checksums() map[string]DatasetInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := make(map[string]DatasetInfo, len(s.datasets))
+	for name, info := range s.datasets {
+		m[name] = info
+	}
+	return m
+}
+
+// checksumReader hashes a file as it is read and calls done with the hex
+// SHA-256 once the end is reached
+type checksumReader struct {
+	f    *os.File
+	h    hash.Hash
+	done func(sum string)
+}
+
+func (r *checksumReader) Read(b []byte) (int, error) {
+	n, err := r.f.Read(b)
+	r.h.Write(b[:n])
+	if err == io.EOF && r.done != nil {
+		r.done(hex.EncodeToString(r.h.Sum(nil)))
+		r.done = nil
+	}
+	return n, err
+}
+
+func (r *checksumReader) Close() error {
+	return r.f.Close()
+}
+
+// open opens the named dataset for reading in the order of its sort
+// specification. A decompressed TSV, as written by DownloadAll, is already
+// sorted and read as is. Otherwise the `.tsv.gz` published by IMDb is
+// decompressed and sorted, into tmp when it is set or while it is read, so
+// that only the compressed datasets need to be kept on disk.
+func (s *dataSource) open(name string) (io.ReadCloser, error) {
+	f, err := os.Open(path.Join(s.dir, name))
+	if err == nil {
+		return s.checksummed(name, f), nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if s.tmp == "" {
+		return s.openCompressed(name)
+	}
+
+	s.mu.Lock()
+	d, ok := s.sorted[name]
+	if !ok {
+		d = &sortedDataset{path: path.Join(s.tmp, name+".gz")}
+		s.sorted[name] = d
+	}
+	s.mu.Unlock()
+
+	// the first open sorts the dataset while the others wait for it
+	d.once.Do(func() { d.err = s.sortInto(name, d.path) })
+	if d.err != nil {
+		return nil, d.err
+	}
+	return openGzip(d.path)
+}
+
+// sortInto sorts the compressed dataset name into the file at dst
+func (s *dataSource) sortInto(name, dst string) error {
+	r, err := s.openCompressed(name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	zw, err := gzip.NewWriterLevel(f, gzip.BestSpeed)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	_, err = io.Copy(zw, r)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// gzipFile reads a gzip compressed file
+type gzipFile struct {
+	*gzip.Reader
+	f *os.File
+}
+
+func openGzip(name string) (*gzipFile, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := gzip.NewReader(bufio.NewReader(f))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &gzipFile{zr, f}, nil
+}
+
+func (g *gzipFile) Close() error {
+	return closeAll(g.Reader, g.f)
+}
+
+// openCompressed opens the compressed dataset name, sorting it while it is
+// read
+func (s *dataSource) openCompressed(name string) (io.ReadCloser, error) {
+	gz, err := os.Open(path.Join(s.dir, name+".gz"))
+	if os.IsNotExist(err) {
+		// report the dataset itself as missing
+		return nil, &os.PathError{Op: "open", Path: path.Join(s.dir, name), Err: os.ErrNotExist}
+	}
+	if err != nil {
+		return nil, err
+	}
+	in := s.checksummed(name, gz)
+	zr, err := gzip.NewReader(in)
+	if err != nil {
+		in.Close()
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		p := s.t.start(name, StageSort, 0)
+		err := writeSortedCSVRecords(&contextReader{s.t.context(), zr}, pw, sortSpecFor(name), s.sort, p)
+		if err == nil {
+			p.finish()
+		}
+		zr.Close()
+		in.Close()
+		pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
@@ -0,0 +1,159 @@
+package imdb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// writeCompressedDatasets writes every test dataset to dir as a `.tsv.gz`
+// with its rows reversed, since IMDb does not publish them sorted
+func writeCompressedDatasets(t *testing.T, dir string) {
+	for _, dataset := range []string{IMDBBasics, IMDBAKAS, IMDBEpisode, IMDBRatings} {
+		data, err := ioutil.ReadFile(path.Join("testdata", dataset))
+		if err != nil {
+			t.Fatalf("failed to read dataset: %v", err)
+		}
+		lines := strings.SplitAfter(string(data), "\n")
+		if lines[len(lines)-1] == "" {
+			lines = lines[:len(lines)-1]
+		}
+		for i, j := 1, len(lines)-1; i < j; i, j = i+1, j-1 {
+			lines[i], lines[j] = lines[j], lines[i]
+		}
+
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		w.Write([]byte(strings.Join(lines, "")))
+		w.Close()
+		if err := ioutil.WriteFile(path.Join(dir, dataset+".gz"), buf.Bytes(), 0644); err != nil {
+			t.Fatalf("failed to write dataset: %v", err)
+		}
+	}
+}
+
+func TestDataSourceOpenCompressed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imdb-dataset")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	writeCompressedDatasets(t, dir)
+
+	src := newDataSource(nil, dir, DefaultSortOptions)
+	r, err := src.open(IMDBRatings)
+	if err != nil {
+		t.Fatalf("failed to open dataset: %v", err)
+	}
+	got, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatalf("failed to read dataset: %v", err)
+	}
+
+	want, err := ioutil.ReadFile(path.Join("testdata", IMDBRatings))
+	if err != nil {
+		t.Fatalf("failed to read dataset: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("incorrect sorted dataset: got=%q want=%q", got, want)
+	}
+
+	// a decompressed TSV is preferred
+	tsv := "tconst\taverageRating\tnumVotes\ntt0000001\t5.0\t10\n"
+	if err := ioutil.WriteFile(path.Join(dir, IMDBRatings), []byte(tsv), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if r, err = src.open(IMDBRatings); err != nil {
+		t.Fatalf("failed to open dataset: %v", err)
+	}
+	got, _ = ioutil.ReadAll(r)
+	r.Close()
+	if string(got) != tsv {
+		t.Fatalf("expected the TSV to be read, got %q", got)
+	}
+}
+
+func TestDataSourceSharedSort(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imdb-dataset")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	writeCompressedDatasets(t, dir)
+	tmp := path.Join(dir, "sorted")
+	if err = os.Mkdir(tmp, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	sorts := 0
+	src := newDataSource(newTracker(context.Background(), func(p Progress) {
+		if p.Stage == StageSort && p.Finished {
+			mu.Lock()
+			sorts++
+			mu.Unlock()
+		}
+	}), dir, DefaultSortOptions)
+	src.tmp = tmp
+
+	want, err := ioutil.ReadFile(path.Join("testdata", IMDBBasics))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	got := make([][]byte, 2)
+	errs := make([]error, 2)
+	for i := range got {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r, err := src.open(IMDBBasics)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			defer r.Close()
+			got[i], errs[i] = ioutil.ReadAll(r)
+		}(i)
+	}
+	wg.Wait()
+	for i := range got {
+		if errs[i] != nil {
+			t.Fatalf("failed to read dataset: %v", errs[i])
+		}
+		if !bytes.Equal(got[i], want) {
+			t.Fatalf("incorrect sorted dataset: got=%q want=%q", got[i], want)
+		}
+	}
+	if sorts != 1 {
+		t.Fatalf("expected the dataset to be sorted once, got %d sorts", sorts)
+	}
+	if _, ok := src.checksums()[IMDBBasics]; !ok {
+		t.Fatalf("expected the checksum of the dataset")
+	}
+
+	// the sorted dataset is kept compressed
+	files, err := ioutil.ReadDir(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name() != IMDBBasics+".gz" || files[0].Size() >= int64(len(want)) {
+		t.Fatalf("expected a single compressed sorted dataset, got %d files", len(files))
+	}
+}
+
+func TestDataSourceOpenMissing(t *testing.T) {
+	src := newDataSource(nil, "testdata/missing", DefaultSortOptions)
+	_, err := src.open(IMDBRatings)
+	if !errors.Is(err, os.ErrNotExist) || !strings.Contains(err.Error(), IMDBRatings) {
+		t.Fatalf("expected a missing dataset error, got %v", err)
+	}
+}
@@ -0,0 +1,9 @@
+// Package imdb builds and searches FST indices over the IMDb datasets
+// (https://www.imdb.com/interfaces/).
+//
+// Each dataset gets its own index. Create builds all of them from a directory
+// of TSV files into one index directory, which Open reopens later.
+// CreateContext is Create with cancellation and progress reports. A Searcher
+// ties the indices together to answer fuzzy name queries parsed by
+// ParseQuery. The record types are in the types package.
+package imdb
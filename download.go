@@ -0,0 +1,500 @@
+package imdb
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const IMDBBaseURL = "https://datasets.imdbws.com"
+
+// dataSets are the compressed files downloaded from the IMDb dataset server
+var dataSets = []string{
+	IMDBAKAS + ".gz",
+	IMDBBasics + ".gz",
+	IMDBEpisode + ".gz",
+	IMDBRatings + ".gz",
+}
+
+// DownloadError is returned when the dataset server answers with a status
+// that is not a success
+type DownloadError struct {
+	URL    string
+	Status int
+}
+
+func (e *DownloadError) Error() string {
+	return fmt.Sprintf("failed to download %s: %d %s", e.URL, e.Status, http.StatusText(e.Status))
+}
+
+// temporary reports whether the request may succeed when retried. A range
+// that cannot be satisfied drops the partial file, so the retry starts over.
+func (e *DownloadError) temporary() bool {
+	return e.Status >= 500 || e.Status == http.StatusTooManyRequests ||
+		e.Status == http.StatusRequestedRangeNotSatisfiable
+}
+
+// errIncomplete is returned when a response ends before the promised length
+var errIncomplete = errors.New("download ended early")
+
+// Downloader fetches the IMDb datasets.
+//
+// The datasets are fetched from an HTTP server such as IMDBBaseURL, or from a
+// mirror of it on the local file system. Local files are read through the
+// same requests as remote ones, so both are decompressed and sorted alike.
+//
+// Every dataset is first downloaded to a `.part` file next to its final
+// path, which is resumed with a range request when a previous attempt was
+// interrupted. Once its size is verified it is renamed into place, then
+// decompressed and sorted into its TSV, again through a temporary file. The
+// size, ETag and modification time of every dataset are recorded in a
+// `.state` file beside it, so that later runs only download datasets that
+// changed on the server.
+type Downloader struct {
+	// Where the datasets are downloaded from: the base URL of an HTTP server,
+	// a file:// URL or the path of a local directory holding the `.tsv.gz`
+	// files. Defaults to IMDBBaseURL.
+	Source string
+	// The client making HTTP requests. Local files are always read directly.
+	Client *http.Client
+	// The number of times a failed request is retried.
+	Retries int
+	// The delay before the first retry, doubled for every following retry.
+	Backoff time.Duration
+	// Sort controls the external sort of the decompressed datasets.
+	Sort SortOptions
+	// CompressedOnly keeps only the `.gz` datasets, which Create decompresses
+	// and sorts while it builds, instead of also writing the sorted TSVs.
+	CompressedOnly bool
+	// Progress, when set, receives reports of the bytes downloaded and the
+	// rows sorted of every dataset.
+	Progress ProgressFunc
+}
+
+// NewDownloader returns a downloader fetching from IMDBBaseURL with the
+// default options
+func NewDownloader() *Downloader {
+	return &Downloader{
+		Source:  IMDBBaseURL,
+		Client:  http.DefaultClient,
+		Retries: 5,
+		Backoff: time.Second,
+		Sort:    DefaultSortOptions,
+	}
+}
+
+// DownloadAll concurrently downloads all of the imdb datasets and writes them
+// sorted and decompressed to dir, using the default options
+func DownloadAll(dir string) ([]string, error) {
+	return NewDownloader().DownloadAll(dir)
+}
+
+// DownloadAll concurrently downloads all of the imdb datasets and writes them
+// sorted and decompressed to dir. Datasets unchanged since the last download
+// into dir are skipped. It returns the names of the datasets that changed,
+// e.g. IMDBBasics, in the order they are downloaded.
+func (d *Downloader) DownloadAll(dir string) ([]string, error) {
+	return d.DownloadAllContext(context.Background(), dir)
+}
+
+// DownloadAllContext is DownloadAll stopping early once ctx is done, in which
+// case partial downloads are kept to be resumed by the next run
+func (d *Downloader) DownloadAllContext(ctx context.Context, dir string) ([]string, error) {
+	base, err := d.sourceURL()
+	if err != nil {
+		return nil, err
+	}
+
+	// make dir
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	changed := make([]bool, len(dataSets))
+	// the first failure stops the other downloads
+	errs, ctx := errgroup.WithContext(ctx)
+	t := newTracker(ctx, d.Progress)
+	for i, set := range dataSets {
+		i, set := i, set
+		errs.Go(func() (err error) {
+			changed[i], err = d.download(t, base, set, dir)
+			return err
+		})
+	}
+
+	if err := errs.Wait(); err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for i, set := range dataSets {
+		if changed[i] {
+			names = append(names, strings.TrimSuffix(set, path.Ext(set)))
+		}
+	}
+	return names, nil
+}
+
+// Downloads a single data set, decompresses it and writes it to the
+// corresponding file path in the given directory. It reports whether the
+// decompressed dataset changed.
+func (d *Downloader) download(t *tracker, base, file, outdir string) (bool, error) {
+	changed, err := d.fetch(t, base, file, outdir)
+	if err != nil {
+		return false, err
+	}
+
+	dataset := path.Join(outdir, strings.TrimSuffix(file, path.Ext(file)))
+	if d.CompressedOnly {
+		// a TSV left by an earlier run would be preferred by Create
+		if err := os.Remove(dataset); err != nil && !os.IsNotExist(err) {
+			return false, err
+		}
+		return changed, nil
+	}
+	if !changed {
+		// the dataset is only missing if sorting failed last time
+		if _, err := os.Stat(dataset); err == nil {
+			return false, nil
+		}
+	}
+	if err := sortDataset(t, path.Join(outdir, file), dataset, d.Sort); err != nil {
+		return false, fmt.Errorf("failed to sort %s: %w", file, err)
+	}
+	return true, nil
+}
+
+// downloadState is what is known about a downloaded dataset
+type downloadState struct {
+	// The ETag of the dataset when it was downloaded.
+	ETag string `json:"etag,omitempty"`
+	// The Last-Modified time of the dataset when it was downloaded.
+	LastModified string `json:"last_modified,omitempty"`
+	// The size of the complete compressed dataset.
+	Size int64 `json:"size"`
+}
+
+func readDownloadState(path string) (*downloadState, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &downloadState{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state downloadState
+	if err = json.Unmarshal(data, &state); err != nil {
+		// a corrupt state only costs a fresh download
+		return &downloadState{}, nil
+	}
+	return &state, nil
+}
+
+// validator returns what identifies the downloaded version of the dataset to
+// the server, preferring the ETag
+func (s *downloadState) validator() string {
+	if s.ETag != "" {
+		return s.ETag
+	}
+	return s.LastModified
+}
+
+func (s *downloadState) write(path string) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// fetch downloads a compressed dataset into dir, retrying with exponential
+// backoff and resuming from whatever a previous attempt left behind. It
+// reports whether a new version of the dataset was downloaded.
+func (d *Downloader) fetch(t *tracker, base, file, dir string) (bool, error) {
+	dst := path.Join(dir, file)
+	part := dst + ".part"
+	statePath := dst + ".state"
+
+	state, err := readDownloadState(statePath)
+	if err != nil {
+		return false, err
+	}
+
+	// only a complete earlier download can be revalidated, anything else is
+	// resumed or downloaded again
+	conditional := false
+	if fi, err := os.Stat(dst); err == nil && fi.Size() == state.Size && state.validator() != "" {
+		if _, err := os.Stat(part); os.IsNotExist(err) {
+			conditional = true
+		}
+	}
+
+	backoff := d.Backoff
+	var modified bool
+	for attempt := 0; ; attempt++ {
+		modified, err = d.fetchOnce(t, base+"/"+file, part, statePath, state, conditional)
+		if err == nil {
+			break
+		}
+		if cerr := t.context().Err(); cerr != nil {
+			return false, cerr
+		}
+
+		var derr *DownloadError
+		if errors.As(err, &derr) && !derr.temporary() {
+			return false, err
+		}
+		if attempt >= d.Retries {
+			return false, fmt.Errorf("giving up on %s after %d attempts: %w", file, attempt+1, err)
+		}
+		select {
+		case <-time.After(backoff):
+		case <-t.context().Done():
+			return false, t.context().Err()
+		}
+		backoff *= 2
+	}
+	if !modified {
+		return false, nil
+	}
+
+	// drop the dataset sorted from the previous version, so that a failure
+	// to sort the new one is noticed by the next run
+	os.Remove(strings.TrimSuffix(dst, path.Ext(dst)))
+	return true, os.Rename(part, dst)
+}
+
+// fetchOnce makes a single request for a dataset, appending to the partial
+// file when the server honours the range. When conditional is set the
+// dataset is only downloaded if it changed since the recorded state, and
+// fetchOnce reports whether it was.
+func (d *Downloader) fetchOnce(t *tracker, src, part, statePath string, state *downloadState, conditional bool) (bool, error) {
+	var offset int64
+	if fi, err := os.Stat(part); err == nil && state.validator() != "" {
+		offset = fi.Size()
+	}
+	// a partial file left by an earlier attempt of this run is resumed
+	conditional = conditional && offset == 0
+	if state.Size > 0 && offset == state.Size {
+		// a previous attempt finished but was not renamed into place
+		return true, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, src, nil)
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(t.context())
+	if conditional {
+		if state.ETag != "" {
+			req.Header.Set("If-None-Match", state.ETag)
+		}
+		if state.LastModified != "" {
+			req.Header.Set("If-Modified-Since", state.LastModified)
+		}
+	} else if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		// the server sends the whole file if it changed since
+		req.Header.Set("If-Range", state.validator())
+	}
+
+	resp, err := d.client(req.URL).Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	total := resp.ContentLength
+	flags := os.O_WRONLY | os.O_CREATE
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		if conditional {
+			return false, nil
+		}
+		return false, &DownloadError{URL: src, Status: resp.StatusCode}
+	case http.StatusOK:
+		offset = 0
+		flags |= os.O_TRUNC
+	case http.StatusPartialContent:
+		start, size, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if !ok || start != offset {
+			// start over rather than guess where the bytes belong
+			os.Remove(part)
+			return false, fmt.Errorf("unexpected content range %q for %s", resp.Header.Get("Content-Range"), src)
+		}
+		total = size
+		flags |= os.O_APPEND
+	case http.StatusRequestedRangeNotSatisfiable:
+		// without a validator the next attempt is a plain GET
+		os.Remove(part)
+		*state = downloadState{}
+		if err = state.write(statePath); err != nil {
+			return false, err
+		}
+		return false, &DownloadError{URL: src, Status: resp.StatusCode}
+	default:
+		return false, &DownloadError{URL: src, Status: resp.StatusCode}
+	}
+
+	state.ETag = resp.Header.Get("ETag")
+	state.LastModified = resp.Header.Get("Last-Modified")
+	state.Size = total
+	if err = state.write(statePath); err != nil {
+		return false, err
+	}
+
+	f, err := os.OpenFile(part, flags, 0644)
+	if err != nil {
+		return false, err
+	}
+	p := t.start(datasetName(src), StageDownload, total)
+	p.add(offset)
+	n, err := io.Copy(&progressWriter{f, p}, resp.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return false, err
+	}
+	if total >= 0 && offset+n != total {
+		return false, fmt.Errorf("%s: got %d of %d bytes: %w", src, offset+n, total, errIncomplete)
+	}
+	p.finish()
+	if total < 0 {
+		state.Size = offset + n
+		return true, state.write(statePath)
+	}
+	return true, nil
+}
+
+// datasetName returns the name of the decompressed dataset of a URL or path
+func datasetName(file string) string {
+	file = path.Base(file)
+	return strings.TrimSuffix(file, path.Ext(file))
+}
+
+// sourceURL returns the URL of the directory holding the datasets, turning a
+// local directory into a file:// URL
+func (d *Downloader) sourceURL() (string, error) {
+	if d.Source == "" {
+		return IMDBBaseURL, nil
+	}
+
+	// a single letter scheme is a Windows drive
+	if u, err := url.Parse(d.Source); err == nil && len(u.Scheme) > 1 {
+		switch u.Scheme {
+		case "http", "https", "file":
+			return strings.TrimSuffix(d.Source, "/"), nil
+		}
+		return "", fmt.Errorf("unsupported dataset source %q", d.Source)
+	}
+
+	abs, err := filepath.Abs(d.Source)
+	if err != nil {
+		return "", err
+	}
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}
+	return strings.TrimSuffix(u.String(), "/"), nil
+}
+
+// fileClient reads file:// URLs from the local file system, answering range
+// and conditional requests like an HTTP server
+var fileClient = &http.Client{Transport: http.NewFileTransport(http.Dir("/"))}
+
+func (d *Downloader) client(u *url.URL) *http.Client {
+	if u.Scheme == "file" {
+		return fileClient
+	}
+	if d.Client == nil {
+		return http.DefaultClient
+	}
+	return d.Client
+}
+
+// parseContentRange parses a `bytes start-end/size` header
+func parseContentRange(s string) (start, size int64, ok bool) {
+	s = strings.TrimPrefix(s, "bytes ")
+	slash := strings.IndexByte(s, '/')
+	dash := strings.IndexByte(s, '-')
+	if slash < 0 || dash < 0 || dash > slash {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(s[:dash], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	size, err = strconv.ParseInt(s[slash+1:], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return start, size, true
+}
+
+// sortDataset decompresses and sorts a downloaded dataset into dst
+func sortDataset(t *tracker, src, dst string, opts SortOptions) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	r, err := gzip.NewReader(&contextReader{t.context(), in})
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	p := t.start(path.Base(dst), StageSort, 0)
+	err = writeFileAtomicFunc(dst, func(w io.Writer) error {
+		return writeSortedCSVRecords(r, w, sortSpecFor(path.Base(dst)), opts, p)
+	})
+	if err != nil {
+		return err
+	}
+	p.finish()
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file beside path and renames it
+// into place, so readers never see a partially written file
+func writeFileAtomic(path string, data []byte) error {
+	return writeFileAtomicFunc(path, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+func writeFileAtomicFunc(dst string, fn func(w io.Writer) error) error {
+	f, err := ioutil.TempFile(path.Dir(dst), path.Base(dst)+".tmp")
+	if err != nil {
+		return err
+	}
+	if err = fn(f); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err = f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err = os.Rename(f.Name(), dst); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
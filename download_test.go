@@ -0,0 +1,615 @@
+package imdb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gzipDatasets compresses the test datasets as served by datasets.imdbws.com
+func gzipDatasets(t *testing.T) map[string][]byte {
+	sets := map[string][]byte{}
+	for _, set := range dataSets {
+		data, err := ioutil.ReadFile(path.Join("testdata", strings.TrimSuffix(set, ".gz")))
+		if err != nil {
+			t.Fatalf("failed to read dataset: %v", err)
+		}
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		w.Write(data)
+		w.Close()
+		sets[set] = buf.Bytes()
+	}
+	return sets
+}
+
+// etagOf returns the ETag the dataset server sends for data
+func etagOf(data []byte) string {
+	return fmt.Sprintf(`"%x"`, sha1.Sum(data))
+}
+
+// datasetServer serves the compressed test datasets with an ETag and a
+// modification time, so that conditional and range requests are honoured.
+// Every request is passed to intercept first, which handles it itself by
+// returning true.
+type datasetServer struct {
+	*httptest.Server
+	sets      map[string][]byte
+	noETag    bool
+	modTime   time.Time
+	mu        sync.Mutex
+	requests  []*http.Request
+	intercept func(w http.ResponseWriter, r *http.Request, n int) bool
+}
+
+func newDatasetServer(t *testing.T) *datasetServer {
+	s := &datasetServer{sets: gzipDatasets(t)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests = append(s.requests, r)
+		n := len(s.requests)
+		intercept := s.intercept
+		s.mu.Unlock()
+		if intercept != nil && intercept(w, r, n) {
+			return
+		}
+
+		name := strings.TrimPrefix(r.URL.Path, "/")
+		s.mu.Lock()
+		data, ok := s.sets[name]
+		noETag, modTime := s.noETag, s.modTime
+		s.mu.Unlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if !noETag {
+			w.Header().Set("ETag", etagOf(data))
+		}
+		http.ServeContent(w, r, name, modTime, bytes.NewReader(data))
+	}))
+	return s
+}
+
+func (s *datasetServer) downloader() *Downloader {
+	d := NewDownloader()
+	d.Source = s.URL
+	d.Backoff = time.Millisecond
+	return d
+}
+
+func (s *datasetServer) requestCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.requests)
+}
+
+func testDownloadDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "imdb-download")
+	if err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	return dir
+}
+
+// checkDownloaded compares every downloaded dataset with the sorted test data
+func checkDownloaded(t *testing.T, dir string) {
+	for _, set := range dataSets {
+		dataset := strings.TrimSuffix(set, ".gz")
+		f, err := os.Open(path.Join("testdata", dataset))
+		if err != nil {
+			t.Fatalf("failed to open dataset: %v", err)
+		}
+		var want bytes.Buffer
+		err = writeSortedCSVRecords(f, &want, sortSpecFor(dataset), DefaultSortOptions, nil)
+		f.Close()
+		if err != nil {
+			t.Fatalf("failed to sort dataset: %v", err)
+		}
+
+		got, err := ioutil.ReadFile(path.Join(dir, dataset))
+		if err != nil {
+			t.Fatalf("failed to read downloaded dataset: %v", err)
+		}
+		if !bytes.Equal(got, want.Bytes()) {
+			t.Fatalf("incorrect %s: got=%q want=%q", dataset, got, want.String())
+		}
+		if _, err := os.Stat(path.Join(dir, set+".part")); !os.IsNotExist(err) {
+			t.Fatalf("partial download of %s left behind: %v", set, err)
+		}
+	}
+}
+
+func TestDownloadAll(t *testing.T) {
+	s := newDatasetServer(t)
+	defer s.Close()
+	dir := testDownloadDir(t)
+	defer os.RemoveAll(dir)
+
+	if _, err := s.downloader().DownloadAll(dir); err != nil {
+		t.Fatalf("failed to download: %v", err)
+	}
+	checkDownloaded(t, dir)
+
+	state, err := readDownloadState(path.Join(dir, IMDBRatings+".gz.state"))
+	if err != nil {
+		t.Fatalf("failed to read state: %v", err)
+	}
+	data := s.sets[IMDBRatings+".gz"]
+	want := downloadState{ETag: etagOf(data), Size: int64(len(data))}
+	if *state != want {
+		t.Fatalf("incorrect state: got=%+v want=%+v", *state, want)
+	}
+}
+
+func TestDownloadRetries(t *testing.T) {
+	s := newDatasetServer(t)
+	defer s.Close()
+	s.intercept = func(w http.ResponseWriter, r *http.Request, n int) bool {
+		if n <= 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return true
+		}
+		return false
+	}
+	dir := testDownloadDir(t)
+	defer os.RemoveAll(dir)
+
+	if _, err := s.downloader().DownloadAll(dir); err != nil {
+		t.Fatalf("failed to download: %v", err)
+	}
+	checkDownloaded(t, dir)
+	if got, want := s.requestCount(), len(dataSets)+3; got != want {
+		t.Fatalf("incorrect number of requests: got=%d want=%d", got, want)
+	}
+}
+
+func TestDownloadGivesUp(t *testing.T) {
+	s := newDatasetServer(t)
+	defer s.Close()
+	s.intercept = func(w http.ResponseWriter, r *http.Request, n int) bool {
+		w.WriteHeader(http.StatusBadGateway)
+		return true
+	}
+	d := s.downloader()
+	d.Retries = 2
+
+	dir := testDownloadDir(t)
+	defer os.RemoveAll(dir)
+
+	_, err := d.fetch(nil, s.URL, IMDBRatings+".gz", dir)
+	var derr *DownloadError
+	if !errors.As(err, &derr) || derr.Status != http.StatusBadGateway {
+		t.Fatalf("expected bad gateway error, got %v", err)
+	}
+	if got := s.requestCount(); got != 3 {
+		t.Fatalf("incorrect number of requests: got=%d want=3", got)
+	}
+}
+
+func TestDownloadNotFound(t *testing.T) {
+	s := newDatasetServer(t)
+	defer s.Close()
+	s.sets = map[string][]byte{}
+
+	dir := testDownloadDir(t)
+	defer os.RemoveAll(dir)
+
+	_, err := s.downloader().fetch(nil, s.URL, IMDBRatings+".gz", dir)
+	var derr *DownloadError
+	if !errors.As(err, &derr) || derr.Status != http.StatusNotFound {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+	if got := s.requestCount(); got != 1 {
+		t.Fatalf("not found should not be retried: got %d requests", got)
+	}
+}
+
+func TestDownloadResume(t *testing.T) {
+	s := newDatasetServer(t)
+	defer s.Close()
+	dir := testDownloadDir(t)
+	defer os.RemoveAll(dir)
+	set := IMDBRatings + ".gz"
+	data := s.sets[set]
+	half := len(data) / 2
+
+	// leave behind what an interrupted download would have
+	dst := path.Join(dir, set)
+	if err := ioutil.WriteFile(dst+".part", data[:half], 0644); err != nil {
+		t.Fatal(err)
+	}
+	state := &downloadState{ETag: etagOf(data), Size: int64(len(data))}
+	if err := state.write(dst + ".state"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.downloader().fetch(nil, s.URL, set, dir); err != nil {
+		t.Fatalf("failed to download: %v", err)
+	}
+	got, err := ioutil.ReadFile(dst)
+	if err != nil {
+		t.Fatalf("failed to read download: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("resumed download differs from the dataset")
+	}
+
+	r := s.requests[0]
+	if want := "bytes=" + strconv.Itoa(half) + "-"; r.Header.Get("Range") != want {
+		t.Fatalf("incorrect range: got=%q want=%q", r.Header.Get("Range"), want)
+	}
+	if r.Header.Get("If-Range") != state.ETag {
+		t.Fatalf("incorrect If-Range: got=%q want=%q", r.Header.Get("If-Range"), state.ETag)
+	}
+}
+
+func TestDownloadRangeNotSatisfiable(t *testing.T) {
+	s := newDatasetServer(t)
+	defer s.Close()
+	s.intercept = func(w http.ResponseWriter, r *http.Request, n int) bool {
+		if r.Header.Get("Range") == "" {
+			return false
+		}
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return true
+	}
+	dir := testDownloadDir(t)
+	defer os.RemoveAll(dir)
+	set := IMDBRatings + ".gz"
+	data := s.sets[set]
+
+	// a partial file the server no longer has a range for
+	dst := path.Join(dir, set)
+	if err := ioutil.WriteFile(dst+".part", data[:len(data)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	state := &downloadState{ETag: etagOf(data), Size: int64(len(data))}
+	if err := state.write(dst + ".state"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.downloader().fetch(nil, s.URL, set, dir); err != nil {
+		t.Fatalf("failed to download: %v", err)
+	}
+	got, err := ioutil.ReadFile(dst)
+	if err != nil {
+		t.Fatalf("failed to read download: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("download differs from the dataset")
+	}
+	if got := s.requestCount(); got != 2 {
+		t.Fatalf("incorrect number of requests: got=%d want=2", got)
+	}
+	if r := s.requests[1]; r.Header.Get("Range") != "" {
+		t.Fatalf("expected the retry to start over, got range %q", r.Header.Get("Range"))
+	}
+}
+
+func TestDownloadResumeChanged(t *testing.T) {
+	s := newDatasetServer(t)
+	defer s.Close()
+	dir := testDownloadDir(t)
+	defer os.RemoveAll(dir)
+	set := IMDBRatings + ".gz"
+	data := s.sets[set]
+
+	// the partial file belongs to an older version of the dataset, so the
+	// server ignores the range and sends all of the new one
+	dst := path.Join(dir, set)
+	if err := ioutil.WriteFile(dst+".part", []byte("stale bytes"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	state := &downloadState{ETag: `"stale"`, Size: int64(len(data))}
+	if err := state.write(dst + ".state"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.downloader().fetch(nil, s.URL, set, dir); err != nil {
+		t.Fatalf("failed to download: %v", err)
+	}
+	got, err := ioutil.ReadFile(dst)
+	if err != nil {
+		t.Fatalf("failed to read download: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("download differs from the dataset")
+	}
+}
+
+func TestDownloadTruncated(t *testing.T) {
+	s := newDatasetServer(t)
+	defer s.Close()
+	set := IMDBRatings + ".gz"
+	data := s.sets[set]
+	s.intercept = func(w http.ResponseWriter, r *http.Request, n int) bool {
+		if n > 1 {
+			return false
+		}
+		// promise the whole file but drop the connection half way
+		w.Header().Set("ETag", etagOf(data))
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Write(data[:len(data)/2])
+		return true
+	}
+	dir := testDownloadDir(t)
+	defer os.RemoveAll(dir)
+
+	if _, err := s.downloader().fetch(nil, s.URL, set, dir); err != nil {
+		t.Fatalf("failed to download: %v", err)
+	}
+	got, err := ioutil.ReadFile(path.Join(dir, set))
+	if err != nil {
+		t.Fatalf("failed to read download: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("download differs from the dataset")
+	}
+	if r := s.requests[1]; r.Header.Get("Range") == "" {
+		t.Fatalf("expected the retry to resume the truncated download")
+	}
+}
+
+func TestDownloadUnchanged(t *testing.T) {
+	s := newDatasetServer(t)
+	defer s.Close()
+	dir := testDownloadDir(t)
+	defer os.RemoveAll(dir)
+	d := s.downloader()
+
+	changed, err := d.DownloadAll(dir)
+	if err != nil {
+		t.Fatalf("failed to download: %v", err)
+	}
+	if len(changed) != len(dataSets) {
+		t.Fatalf("expected every dataset to change on the first download, got %v", changed)
+	}
+
+	before := s.requestCount()
+	changed, err = d.DownloadAll(dir)
+	if err != nil {
+		t.Fatalf("failed to download: %v", err)
+	}
+	if len(changed) != 0 {
+		t.Fatalf("expected no dataset to change, got %v", changed)
+	}
+	for _, r := range s.requests[before:] {
+		if r.Header.Get("If-None-Match") == "" {
+			t.Fatalf("expected a conditional request for %s", r.URL.Path)
+		}
+	}
+	checkDownloaded(t, dir)
+
+	// a new version of one dataset is downloaded and sorted again
+	set := IMDBRatings + ".gz"
+	s.mu.Lock()
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte("tconst\taverageRating\tnumVotes\ntt0000002\t5.0\t10\ntt0000001\t6.0\t20\n"))
+	w.Close()
+	s.sets[set] = buf.Bytes()
+	s.mu.Unlock()
+
+	changed, err = d.DownloadAll(dir)
+	if err != nil {
+		t.Fatalf("failed to download: %v", err)
+	}
+	if len(changed) != 1 || changed[0] != IMDBRatings {
+		t.Fatalf("expected only %s to change, got %v", IMDBRatings, changed)
+	}
+	got, err := ioutil.ReadFile(path.Join(dir, IMDBRatings))
+	if err != nil {
+		t.Fatalf("failed to read dataset: %v", err)
+	}
+	want := "tconst\taverageRating\tnumVotes\ntt0000001\t6.0\t20\ntt0000002\t5.0\t10\n"
+	if string(got) != want {
+		t.Fatalf("incorrect dataset: got=%q want=%q", got, want)
+	}
+}
+
+func TestDownloadUnmodifiedSince(t *testing.T) {
+	s := newDatasetServer(t)
+	defer s.Close()
+	s.noETag = true
+	s.modTime = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	dir := testDownloadDir(t)
+	defer os.RemoveAll(dir)
+	d := s.downloader()
+	set := IMDBRatings + ".gz"
+
+	if _, err := d.fetch(nil, s.URL, set, dir); err != nil {
+		t.Fatalf("failed to download: %v", err)
+	}
+	changed, err := d.fetch(nil, s.URL, set, dir)
+	if err != nil {
+		t.Fatalf("failed to download: %v", err)
+	}
+	if changed {
+		t.Fatalf("expected the dataset to be unchanged")
+	}
+	if got, want := s.requests[1].Header.Get("If-Modified-Since"), s.modTime.Format(http.TimeFormat); got != want {
+		t.Fatalf("incorrect If-Modified-Since: got=%q want=%q", got, want)
+	}
+
+	s.mu.Lock()
+	s.modTime = s.modTime.Add(time.Hour)
+	s.mu.Unlock()
+	if changed, err = d.fetch(nil, s.URL, set, dir); err != nil {
+		t.Fatalf("failed to download: %v", err)
+	}
+	if !changed {
+		t.Fatalf("expected the dataset to have changed")
+	}
+}
+
+// writeMirror writes the compressed test datasets to a new directory
+func writeMirror(t *testing.T) string {
+	dir := testDownloadDir(t)
+	for set, data := range gzipDatasets(t) {
+		if err := ioutil.WriteFile(path.Join(dir, set), data, 0644); err != nil {
+			t.Fatalf("failed to write mirror: %v", err)
+		}
+	}
+	return dir
+}
+
+func TestDownloadFromDirectory(t *testing.T) {
+	mirror := writeMirror(t)
+	defer os.RemoveAll(mirror)
+	d := NewDownloader()
+	d.Source = mirror
+	dir := testDownloadDir(t)
+	defer os.RemoveAll(dir)
+
+	changed, err := d.DownloadAll(dir)
+	if err != nil {
+		t.Fatalf("failed to download: %v", err)
+	}
+	if len(changed) != len(dataSets) {
+		t.Fatalf("expected every dataset to change, got %v", changed)
+	}
+	checkDownloaded(t, dir)
+
+	// the modification times of the mirror make re-runs conditional too
+	if changed, err = d.DownloadAll(dir); err != nil {
+		t.Fatalf("failed to download: %v", err)
+	}
+	if len(changed) != 0 {
+		t.Fatalf("expected no dataset to change, got %v", changed)
+	}
+}
+
+func TestDownloadFromFileURL(t *testing.T) {
+	mirror := writeMirror(t)
+	defer os.RemoveAll(mirror)
+	d := NewDownloader()
+	d.Source = (&url.URL{Scheme: "file", Path: mirror}).String()
+	dir := testDownloadDir(t)
+	defer os.RemoveAll(dir)
+
+	if _, err := d.DownloadAll(dir); err != nil {
+		t.Fatalf("failed to download: %v", err)
+	}
+	checkDownloaded(t, dir)
+
+	// a missing dataset is reported like a missing URL
+	os.Remove(path.Join(mirror, IMDBRatings+".gz"))
+	os.Remove(path.Join(dir, IMDBRatings+".gz"))
+	_, err := d.DownloadAll(dir)
+	var derr *DownloadError
+	if !errors.As(err, &derr) || derr.Status != http.StatusNotFound {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+}
+
+func TestDownloadUnsupportedSource(t *testing.T) {
+	d := NewDownloader()
+	d.Source = "ftp://example.com/imdb"
+	dir := testDownloadDir(t)
+	defer os.RemoveAll(dir)
+	if _, err := d.DownloadAll(dir); err == nil {
+		t.Fatalf("expected an error for an ftp source")
+	}
+}
+
+func TestDownloadCompressedOnly(t *testing.T) {
+	s := newDatasetServer(t)
+	defer s.Close()
+	dir := testDownloadDir(t)
+	defer os.RemoveAll(dir)
+	d := s.downloader()
+	if _, err := d.DownloadAll(dir); err != nil {
+		t.Fatalf("failed to download: %v", err)
+	}
+
+	d.CompressedOnly = true
+	if _, err := d.DownloadAll(dir); err != nil {
+		t.Fatalf("failed to download: %v", err)
+	}
+	for set, data := range s.sets {
+		got, err := ioutil.ReadFile(path.Join(dir, set))
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("expected %s to be kept: %v", set, err)
+		}
+		if _, err := os.Stat(path.Join(dir, strings.TrimSuffix(set, ".gz"))); !os.IsNotExist(err) {
+			t.Fatalf("expected the TSV of %s to be removed: %v", set, err)
+		}
+	}
+}
+
+func TestDownloadProgress(t *testing.T) {
+	s := newDatasetServer(t)
+	defer s.Close()
+	d := s.downloader()
+	var mu sync.Mutex
+	finished := map[string]Progress{}
+	d.Progress = func(p Progress) {
+		mu.Lock()
+		defer mu.Unlock()
+		if p.Finished {
+			finished[p.Name+" "+string(p.Stage)] = p
+		}
+	}
+
+	dir := testDownloadDir(t)
+	defer os.RemoveAll(dir)
+	if _, err := d.DownloadAll(dir); err != nil {
+		t.Fatalf("failed to download: %v", err)
+	}
+	for set, data := range s.sets {
+		dataset := strings.TrimSuffix(set, ".gz")
+		p := finished[dataset+" "+string(StageDownload)]
+		if p.Count != int64(len(data)) || p.Total != int64(len(data)) {
+			t.Fatalf("incorrect download progress of %s: %+v", dataset, p)
+		}
+		if p := finished[dataset+" "+string(StageSort)]; p.Count == 0 {
+			t.Fatalf("expected rows of %s to be sorted, got %+v", dataset, p)
+		}
+	}
+}
+
+func TestDownloadCancelled(t *testing.T) {
+	s := newDatasetServer(t)
+	defer s.Close()
+	s.intercept = func(w http.ResponseWriter, r *http.Request, n int) bool {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return true
+	}
+	d := s.downloader()
+	d.Backoff = time.Hour
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	dir := testDownloadDir(t)
+	defer os.RemoveAll(dir)
+	if _, err := d.DownloadAllContext(ctx, dir); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the download to be cancelled while backing off, got %v", err)
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	start, size, ok := parseContentRange("bytes 100-199/200")
+	if !ok || start != 100 || size != 200 {
+		t.Fatalf("incorrect content range: start=%d size=%d ok=%v", start, size, ok)
+	}
+	for _, s := range []string{"", "bytes */200", "bytes 100-199/*"} {
+		if _, _, ok := parseContentRange(s); ok {
+			t.Fatalf("expected %q to be rejected", s)
+		}
+	}
+}
@@ -1,18 +1,16 @@
-package main
+package imdb
 
 import (
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
-	"log"
-	"os"
 	"path"
 	"sort"
 	"strconv"
 
 	"github.com/couchbase/vellum"
-	"github.com/jbpratt78/imdb-index/internal/types"
+	"github.com/jbpratt78/imdb-index/types"
 )
 
 // EpisodeIndex allows for searching of tvshows and seasons indices
@@ -32,8 +30,9 @@ const (
 	TVSHOWS = "episode.tvshows.fst"
 )
 
-// EpisodeOpen opens an index from a previously created `Create` call
-func EpisodeOpen(indexDir string) (*EpisodeIndex, error) {
+// episodeOpen opens the files of its index in indexDir, which Open checks
+// against the manifest first
+func episodeOpen(indexDir string) (*EpisodeIndex, error) {
 	seasons, err := fstSetFile(path.Join(indexDir, SEASONS))
 	if err != nil {
 		return nil, err
@@ -41,23 +40,29 @@ func EpisodeOpen(indexDir string) (*EpisodeIndex, error) {
 
 	tvshows, err := fstSetFile(path.Join(indexDir, TVSHOWS))
 	if err != nil {
+		seasons.Close()
 		return nil, err
 	}
 
 	return &EpisodeIndex{tvshows, seasons}, nil
 }
 
-// EpisodeCreate creates a new index and opens it
-func EpisodeCreate(dataDir, indexDir string) (*EpisodeIndex, error) {
+// Close releases both FSTs
+func (i *EpisodeIndex) Close() error {
+	return closeAll(i.tvshows, i.seasons)
+}
+
+func episodeCreate(src *dataSource, indexDir string) (*EpisodeIndex, error) {
+	t := src.t
 	fstShowFile := path.Join(indexDir, TVSHOWS)
 	fstSeasonFile := path.Join(indexDir, SEASONS)
-	tsv, err := os.Open(path.Join(dataDir, IMDBEpisode))
+	tsv, err := src.open(IMDBEpisode)
 	if err != nil {
 		return nil, err
 	}
 	defer tsv.Close()
 
-	episodes, err := readSortedEpisodes(tsv)
+	episodes, err := readSortedEpisodes(tsv, t.start(IMDBEpisode, StageParse, 0))
 	if err != nil {
 		return nil, EpisodeError(fmt.Sprintf("failed to read episodes tsv: %v", err))
 	}
@@ -80,6 +85,7 @@ func EpisodeCreate(dataDir, indexDir string) (*EpisodeIndex, error) {
 		return nil, fmt.Errorf("failed to create fst set builder: %w", err)
 	}
 
+	p := t.start(SEASONS, StageInsert, int64(len(episodes)))
 	for i, ep := range episodes {
 		buffer, err := writeEpisode(ep)
 		if err != nil {
@@ -88,12 +94,18 @@ func EpisodeCreate(dataDir, indexDir string) (*EpisodeIndex, error) {
 		if err = seasonBuilder.Insert(buffer, uint64(i)); err != nil {
 			return nil, fmt.Errorf("failed to insert episode into season builder: %w", err)
 		}
+		if err = p.add(1); err != nil {
+			return nil, err
+		}
 	}
+	p.finish()
 
 	if err = seasonBuilder.Close(); err != nil {
 		return nil, fmt.Errorf("failed to close season builder: %w", err)
 	}
-	seasonIndexFile.Close()
+	if err = seasonIndexFile.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close season index file: %w", err)
+	}
 
 	tvBuilder, tvIndexFile, err := fstSetBuilderFile(fstShowFile)
 	if err != nil {
@@ -107,6 +119,7 @@ func EpisodeCreate(dataDir, indexDir string) (*EpisodeIndex, error) {
 		return episodes[i].TvShowID < episodes[j].TvShowID
 	})
 
+	p = t.start(TVSHOWS, StageInsert, int64(len(episodes)))
 	for i, ep := range episodes {
 		buffer, err := writeTvshow(ep)
 		if err != nil {
@@ -115,14 +128,20 @@ func EpisodeCreate(dataDir, indexDir string) (*EpisodeIndex, error) {
 		if err = tvBuilder.Insert(buffer, uint64(i)); err != nil {
 			return nil, fmt.Errorf("failed to insert into tv builder: %w", err)
 		}
+		if err = p.add(1); err != nil {
+			return nil, err
+		}
 	}
+	p.finish()
 
-	if tvBuilder.Close(); err != nil {
+	if err = tvBuilder.Close(); err != nil {
 		return nil, fmt.Errorf("failed to close tv builder: %w", err)
 	}
-	tvIndexFile.Close()
+	if err = tvIndexFile.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close tv index file: %w", err)
+	}
 
-	return EpisodeOpen(indexDir)
+	return episodeOpen(indexDir)
 }
 
 func episodeRange(
@@ -132,6 +151,9 @@ func episodeRange(
 ) ([]*types.Episode, error) {
 	var eps []*types.Episode
 	itr, err := fst.Iterator(lower, upper)
+	if errors.Is(err, vellum.ErrIteratorDone) {
+		return nil, nil
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -147,45 +169,153 @@ func episodeRange(
 	if errors.Is(err, vellum.ErrIteratorDone) {
 		return eps, nil
 	}
-	return nil, EpisodeError("iterator did not finish")
+	return nil, fmt.Errorf("failed to iterate episodes: %v: %w", err, ErrCorruptIndex)
 }
 
-func (i *EpisodeIndex) Seasons(tvshowId []uint8, season uint32) ([]*types.Episode, error) {
-	return episodeRange(tvshowId, append(tvshowId, 0xFF), i.seasons, readEpisode)
+// Season summarizes a season of a TV show
+type Season struct {
+	// The season number, 0 for the specials or types.UnknownNumber for the
+	// episodes without a season.
+	Number uint32 `json:"number"`
+	// The number of episodes of the season.
+	Episodes int `json:"episodes"`
 }
 
+// Seasons returns the seasons of a show with their number of episodes,
+// ordered by season number
+func (i *EpisodeIndex) Seasons(tvshowId []uint8) ([]Season, error) {
+	eps, err := i.ShowEpisodes(tvshowId)
+	if err != nil {
+		return nil, err
+	}
+	var seasons []Season
+	for _, ep := range eps {
+		if n := len(seasons); n > 0 && seasons[n-1].Number == ep.Season {
+			seasons[n-1].Episodes++
+			continue
+		}
+		seasons = append(seasons, Season{Number: ep.Season, Episodes: 1})
+	}
+	return seasons, nil
+}
+
+// Episodes returns the episodes of a season of a show, ordered by episode
+// number with the unnumbered episodes of the season last. Season 0 holds the
+// specials and types.UnknownNumber the episodes without a season.
 func (i *EpisodeIndex) Episodes(tvshowId []uint8, season uint32) ([]*types.Episode, error) {
-	lower := append(tvshowId, 0x00)
-	upper := append(tvshowId, 0x00)
-	buff := make([]byte, 4)
+	lower, upper := prefixBounds(seasonsKey(tvshowId, season))
+	return episodeRange(lower, upper, i.seasons, readEpisode)
+}
 
-	binary.BigEndian.PutUint32(buff, season)
-	lower = append(lower, buff...)
+// Specials returns the episodes of season 0 of a show
+func (i *EpisodeIndex) Specials(tvshowId []uint8) ([]*types.Episode, error) {
+	return i.Episodes(tvshowId, 0)
+}
 
-	buff = make([]byte, 4)
-	binary.BigEndian.PutUint32(buff, 0)
-	lower = append(lower, buff...)
+// Unnumbered returns the episodes of a show with an unknown season or
+// episode number
+func (i *EpisodeIndex) Unnumbered(tvshowId []uint8) ([]*types.Episode, error) {
+	eps, err := i.ShowEpisodes(tvshowId)
+	if err != nil {
+		return nil, err
+	}
+	var unnumbered []*types.Episode
+	for _, ep := range eps {
+		if !ep.IsNumbered() {
+			unnumbered = append(unnumbered, ep)
+		}
+	}
+	return unnumbered, nil
+}
 
-	buff = make([]byte, 4)
-	binary.BigEndian.PutUint32(buff, season)
-	upper = append(upper, buff...)
+// Episode returns the episode record of the given episode id
+func (i *EpisodeIndex) Episode(epId []uint8) (*types.Episode, error) {
+	ep, ok, err := i.Lookup(epId)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("episode %q: %w", epId, ErrNotFound)
+	}
+	return ep, nil
+}
 
-	buff = make([]byte, 4)
-	binary.BigEndian.PutUint32(buff, ^uint32(0))
-	upper = append(upper, buff...)
+// EpisodeAt returns the episode of a show with the given season and episode
+// number. Of several episodes sharing a number, the one with the smallest id
+// is returned.
+func (i *EpisodeIndex) EpisodeAt(tvshowId []uint8, season, episode uint32) (*types.Episode, error) {
+	lower, upper := prefixBounds(seasonsKey(tvshowId, season, episode))
+	eps, err := episodeRange(lower, upper, i.seasons, readEpisode)
+	if err != nil {
+		return nil, err
+	}
+	if len(eps) == 0 {
+		return nil, fmt.Errorf("episode %d of season %d of %q: %w", episode, season, tvshowId, ErrNotFound)
+	}
+	return eps[0], nil
+}
+
+// Next returns the episode of the same show following the given episode, in
+// the order of ShowEpisodes, and whether there is one
+func (i *EpisodeIndex) Next(epId []uint8) (*types.Episode, bool, error) {
+	ep, ok, err := i.Lookup(epId)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	// the key of the episode itself is the smallest key to skip
+	key, err := writeEpisode(ep)
+	if err != nil {
+		return nil, false, err
+	}
+	_, upper := exactBounds([]byte(ep.TvShowID))
+	eps, err := episodeRange(append(key, 0x00), upper, i.seasons, readEpisode)
+	if err != nil || len(eps) == 0 {
+		return nil, false, err
+	}
+	return eps[0], true, nil
+}
 
+// Previous returns the episode of the same show preceding the given
+// episode, in the order of ShowEpisodes, and whether there is one
+func (i *EpisodeIndex) Previous(epId []uint8) (*types.Episode, bool, error) {
+	ep, ok, err := i.Lookup(epId)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	key, err := writeEpisode(ep)
+	if err != nil {
+		return nil, false, err
+	}
+	lower, _ := exactBounds([]byte(ep.TvShowID))
+	eps, err := episodeRange(lower, key, i.seasons, readEpisode)
+	if err != nil || len(eps) == 0 {
+		return nil, false, err
+	}
+	return eps[len(eps)-1], true, nil
+}
+
+// ShowEpisodes returns every episode of exactly the given show, ordered by
+// season and episode number
+func (i *EpisodeIndex) ShowEpisodes(tvshowId []uint8) ([]*types.Episode, error) {
+	lower, upper := exactBounds(tvshowId)
 	return episodeRange(lower, upper, i.seasons, readEpisode)
 }
 
-func (i *EpisodeIndex) Episode(epId []uint8) (*types.Episode, error) {
-	eps, err := episodeRange(epId, append(epId, 0xFF), i.tvshows, readTvshow)
+// Lookup returns the episode record of exactly the given episode id and
+// whether it exists
+func (i *EpisodeIndex) Lookup(epId []uint8) (*types.Episode, bool, error) {
+	lower, upper := exactBounds(epId)
+	eps, err := episodeRange(lower, upper, i.tvshows, readTvshow)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
-	return eps[0], nil
+	if len(eps) == 0 {
+		return nil, false, nil
+	}
+	return eps[0], true, nil
 }
 
-func readSortedEpisodes(in *os.File) ([]*types.Episode, error) {
+func readSortedEpisodes(in io.Reader, p *progress) ([]*types.Episode, error) {
 	var episodes []*types.Episode
 	header := []string{}
 
@@ -206,27 +336,46 @@ func readSortedEpisodes(in *os.File) ([]*types.Episode, error) {
 			header = rec
 			continue
 		}
+		if err = p.add(1); err != nil {
+			return nil, err
+		}
 
-		season, err := strconv.ParseUint(rec[2], 10, 32)
+		season, err := parseEpisodeNumber(rec[2])
 		if err != nil {
-			fmt.Println("failed to parse", rec)
-			continue
+			return nil, EpisodeError(fmt.Sprintf("invalid season of %s: %v", rec[0], err))
 		}
-		episode, err := strconv.ParseUint(rec[3], 10, 32)
+		episode, err := parseEpisodeNumber(rec[3])
 		if err != nil {
-			continue
+			return nil, EpisodeError(fmt.Sprintf("invalid episode number of %s: %v", rec[0], err))
 		}
 
 		episodes = append(episodes, &types.Episode{
 			Id:       rec[0],
 			TvShowID: rec[1],
-			Season:   uint32(season),
-			Episode:  uint32(episode),
+			Season:   season,
+			Episode:  episode,
 		})
 	}
+	p.finish()
 	return episodes, nil
 }
 
+// parseEpisodeNumber parses a season or episode number, where `\N` is
+// types.UnknownNumber
+func parseEpisodeNumber(field string) (uint32, error) {
+	if field == `\N` {
+		return types.UnknownNumber, nil
+	}
+	n, err := strconv.ParseUint(field, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	if uint32(n) == types.UnknownNumber {
+		return 0, fmt.Errorf("%d is out of range", n)
+	}
+	return uint32(n), nil
+}
+
 func readEpisode(key []byte) *types.Episode {
 	nul := 0
 	for i, b := range key {
@@ -255,6 +404,18 @@ func readEpisode(key []byte) *types.Episode {
 	}
 }
 
+// seasonsKey returns the start of the seasons keys of a show with the given
+// season and episode numbers, in that order
+func seasonsKey(tvshowId []uint8, numbers ...uint32) []byte {
+	key := append(append([]byte{}, tvshowId...), 0x00)
+	buff := make([]byte, 4)
+	for _, n := range numbers {
+		binary.BigEndian.PutUint32(buff, n)
+		key = append(key, buff...)
+	}
+	return key
+}
+
 func writeEpisode(ep *types.Episode) ([]uint8, error) {
 	buffer := []uint8{}
 	for _, b := range []byte(ep.TvShowID) {
@@ -267,11 +428,11 @@ func writeEpisode(ep *types.Episode) ([]uint8, error) {
 	buffer = append(buffer, 0x00)
 
 	y := make([]byte, 4)
-	binary.BigEndian.PutUint32(y, valOrMax(ep.Season))
+	binary.BigEndian.PutUint32(y, ep.Season)
 	buffer = append(buffer, y...)
 
 	y = make([]byte, 4)
-	binary.BigEndian.PutUint32(y, valOrMax(ep.Episode))
+	binary.BigEndian.PutUint32(y, ep.Episode)
 	buffer = append(buffer, y...)
 	buffer = append(buffer, []uint8(ep.Id)...)
 
@@ -318,23 +479,14 @@ func writeTvshow(ep *types.Episode) ([]uint8, error) {
 	buffer = append(buffer, 0x00)
 
 	y := make([]byte, 4)
-	binary.BigEndian.PutUint32(y, valOrMax(ep.Season))
+	binary.BigEndian.PutUint32(y, ep.Season)
 	buffer = append(buffer, y...)
 
 	y = make([]byte, 4)
-	binary.BigEndian.PutUint32(y, valOrMax(ep.Episode))
+	binary.BigEndian.PutUint32(y, ep.Episode)
 	buffer = append(buffer, y...)
 
 	buffer = append(buffer, []uint8(ep.TvShowID)...)
 
 	return buffer, nil
 }
-
-func valOrMax(val uint32) uint32 {
-	if val != 0 {
-		return val
-	} else if val == ^uint32(0) {
-		log.Fatal(EpisodeError("unsupported number"))
-	}
-	return ^uint32(0)
-}
@@ -1,66 +1,103 @@
-package main
+package imdb
 
 import (
+	"encoding/json"
 	"errors"
+	"io/ioutil"
 	"os"
+	"path"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/couchbase/vellum"
+	"github.com/jbpratt78/imdb-index/types"
 )
 
 var tmpDir string
 
 func TestMain(m *testing.M) {
-	tmpDir = os.TempDir()
-	defer os.RemoveAll(tmpDir)
-
-	_, err := EpisodeCreate("testdata", tmpDir)
+	var err error
+	parent, err := ioutil.TempDir("", "imdb-index")
 	if err != nil {
 		panic(err)
 	}
+	tmpDir = path.Join(parent, "index")
 
-	_, err = RatingsCreate("testdata", tmpDir)
+	idx, err := Create("testdata", tmpDir)
 	if err != nil {
 		panic(err)
 	}
+	idx.Close()
 
-	os.Exit(m.Run())
+	code := m.Run()
+	os.RemoveAll(parent)
+	os.Exit(code)
 }
 
 func TestEpisodeBasic(t *testing.T) {
-	idx, err := EpisodeOpen(tmpDir)
+	idx, err := episodeOpen(tmpDir)
 	if err != nil {
 		t.Fatalf("failed to open episode indicies: %v", err)
 	}
-	eps, err := idx.Seasons([]byte("tt0096697"), 2)
+	defer idx.Close()
+	seasons, err := idx.Seasons([]byte("tt0096697"))
 	if err != nil {
-		if errors.Is(err, vellum.ErrIteratorDone) {
-		} else {
-			panic(err)
+		t.Fatalf("failed to list seasons: %v", err)
+	}
+
+	want := []Season{{1, 13}, {2, 22}, {3, 24}}
+	if !reflect.DeepEqual(seasons, want) {
+		t.Fatalf("incorrect seasons: got=%v want=%v", seasons, want)
+	}
+
+	// only exactly the given show matches
+	for _, show := range []string{"tt009669", "tt00966970"} {
+		if seasons, err := idx.Seasons([]byte(show)); err != nil || len(seasons) != 0 {
+			t.Fatalf("expected no seasons for %s: %v %v", show, seasons, err)
 		}
 	}
+}
 
-	counts := make(map[uint32]uint32)
-	for _, ep := range eps {
-		counts[ep.Season] += 1
+func TestEpisodeNavigation(t *testing.T) {
+	idx, err := episodeOpen(tmpDir)
+	if err != nil {
+		t.Fatalf("failed to open episode indicies: %v", err)
 	}
+	defer idx.Close()
+	show := []byte("tt0096697")
 
-	if len(counts) != 3 {
-		t.Fatalf("got the wrong amount of episodes: got=%d want=%d", len(counts), 3)
+	ep, err := idx.EpisodeAt(show, 2, 1)
+	if err != nil || ep.Id != "tt0763024" {
+		t.Fatalf("incorrect episode: %+v %v", ep, err)
 	}
-	if counts[1] != 13 {
-		t.Fatalf("got the wrong count: got=%d want=%d", counts[1], 13)
+	if _, err := idx.EpisodeAt(show, 2, 99); err == nil {
+		t.Fatalf("expected error for missing episode")
 	}
-	if counts[2] != 22 {
-		t.Fatalf("got the wrong count: got=%d want=%d", counts[2], 22)
+
+	// navigation crosses seasons
+	next, ok, err := idx.Next([]byte("tt0701215"))
+	if err != nil || !ok || next.Id != "tt0763024" {
+		t.Fatalf("incorrect next episode: %+v %v %v", next, ok, err)
+	}
+	prev, ok, err := idx.Previous([]byte("tt0763024"))
+	if err != nil || !ok || prev.Id != "tt0701215" {
+		t.Fatalf("incorrect previous episode: %+v %v %v", prev, ok, err)
+	}
+
+	if _, ok, err := idx.Previous([]byte("tt0348034")); err != nil || ok {
+		t.Fatalf("expected no episode before the first: %v %v", ok, err)
+	}
+	if _, ok, err := idx.Next([]byte("tt0701076")); err != nil || ok {
+		t.Fatalf("expected no episode after the last: %v %v", ok, err)
 	}
-	if counts[3] != 24 {
-		t.Fatalf("got the wrong count: got=%d want=%d", counts[3], 24)
+	if _, ok, err := idx.Next([]byte("tt9999999")); err != nil || ok {
+		t.Fatalf("expected no episode after a missing one: %v %v", ok, err)
 	}
 }
 
 func TestBySeason(t *testing.T) {
-	idx, err := EpisodeOpen(tmpDir)
+	idx, err := episodeOpen(tmpDir)
 	if err != nil {
 		t.Fatalf("failed to create indices: %v", err)
 	}
@@ -85,7 +122,7 @@ func TestBySeason(t *testing.T) {
 }
 
 func TestTvshow(t *testing.T) {
-	idx, err := EpisodeOpen(tmpDir)
+	idx, err := episodeOpen(tmpDir)
 	if err != nil {
 		t.Fatalf("failed to create indices: %v", err)
 	}
@@ -93,13 +130,95 @@ func TestTvshow(t *testing.T) {
 
 	ep, err := idx.Episode([]byte("tt0701063"))
 	if err != nil {
-		if errors.Is(err, vellum.ErrIteratorDone) {
-		} else {
-			panic(err)
-		}
+		t.Fatalf("failed to get episode: %v", err)
 	}
 
 	if ep.TvShowID != want {
 		t.Fatalf("incorrect tvshowid: got=%q want=%q", ep.TvShowID, want)
 	}
+
+	if _, err := idx.Episode([]byte("tt07010")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected not found error for an episode id prefix, got: %v", err)
+	}
+}
+
+func TestEpisodeSpecials(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "imdb-index-specials")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dataDir)
+	tsv := "tconst\tparentTconst\tseasonNumber\tepisodeNumber\n" +
+		"tt0000001\ttt0000010\t0\t1\n" +
+		"tt0000002\ttt0000010\t0\t0\n" +
+		"tt0000003\ttt0000010\t1\t1\n" +
+		"tt0000004\ttt0000010\t1\t\\N\n" +
+		"tt0000005\ttt0000010\t\\N\t\\N\n" +
+		"tt0000006\ttt0000011\t0\t1\n"
+	if err = ioutil.WriteFile(path.Join(dataDir, IMDBEpisode), []byte(tsv), 0644); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := episodeCreate(newDataSource(nil, dataDir, DefaultSortOptions), dataDir)
+	if err != nil {
+		t.Fatalf("failed to create episode index: %v", err)
+	}
+	defer idx.Close()
+
+	ids := func(eps []*types.Episode) []string {
+		var ids []string
+		for _, ep := range eps {
+			ids = append(ids, ep.Id)
+		}
+		return ids
+	}
+	show := []byte("tt0000010")
+
+	specials, err := idx.Specials(show)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(specials); !reflect.DeepEqual(got, []string{"tt0000002", "tt0000001"}) {
+		t.Fatalf("incorrect specials: %v", got)
+	}
+	season, err := idx.Episodes(show, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(season); !reflect.DeepEqual(got, []string{"tt0000003", "tt0000004"}) {
+		t.Fatalf("incorrect season: %v", got)
+	}
+	unnumbered, err := idx.Unnumbered(show)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(unnumbered); !reflect.DeepEqual(got, []string{"tt0000004", "tt0000005"}) {
+		t.Fatalf("incorrect unnumbered episodes: %v", got)
+	}
+
+	ep, ok, err := idx.Lookup([]byte("tt0000005"))
+	if err != nil || !ok {
+		t.Fatalf("failed to find unnumbered episode: %v", err)
+	}
+	if ep.Season != types.UnknownNumber || ep.Episode != types.UnknownNumber {
+		t.Fatalf("incorrect unnumbered episode: %+v", ep)
+	}
+	data, err := json.Marshal(ep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"id":"tt0000005","tv_show_id":"tt0000010","season":null,"episode":null}`; string(data) != want {
+		t.Fatalf("incorrect JSON: got=%s want=%s", data, want)
+	}
+	var decoded types.Episode
+	if err = json.Unmarshal(data, &decoded); err != nil || decoded != *ep {
+		t.Fatalf("incorrect decoded episode: %+v %v", decoded, err)
+	}
+}
+
+func TestEpisodeInvalidNumber(t *testing.T) {
+	in := strings.NewReader("tconst\tparentTconst\tseasonNumber\tepisodeNumber\ntt0000001\ttt0000010\tx\t1\n")
+	var eerr EpisodeError
+	if _, err := readSortedEpisodes(in, nil); !errors.As(err, &eerr) {
+		t.Fatalf("expected an episode error, got: %v", err)
+	}
 }
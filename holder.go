@@ -0,0 +1,159 @@
+package imdb
+
+import (
+	"context"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+// Holder holds the index of a directory for a long-running process and
+// swaps in a new index when the directory is rebuilt by Create, so that a
+// server picks up a nightly build without a restart.
+//
+// Every query acquires the current index and releases it once done. A
+// reload opens the new index beside the current one and only swaps it in
+// for later acquisitions: the replaced index is closed once the last query
+// using it releases it.
+type Holder struct {
+	dir string
+
+	mu  sync.Mutex
+	cur *heldIndex
+	// the manifest file the current index was checked against
+	manifest os.FileInfo
+	closed   bool
+}
+
+// heldIndex counts the references to an index, the holder's own included
+type heldIndex struct {
+	idx  *Index
+	refs int
+}
+
+// HolderOpen opens the index in indexDir and holds it
+func HolderOpen(indexDir string) (*Holder, error) {
+	fi, err := os.Stat(path.Join(indexDir, MANIFEST))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	idx, err := Open(indexDir)
+	if err != nil {
+		return nil, err
+	}
+	return &Holder{dir: indexDir, cur: &heldIndex{idx: idx, refs: 1}, manifest: fi}, nil
+}
+
+// Acquire returns the current index and the function releasing it, which
+// must be called once the index is no longer used. The index stays open
+// until then, even if a reload replaces it.
+func (h *Holder) Acquire() (*Index, func()) {
+	h.mu.Lock()
+	held := h.cur
+	held.refs++
+	h.mu.Unlock()
+
+	var once sync.Once
+	return held.idx, func() { once.Do(func() { h.release(held) }) }
+}
+
+// release drops a reference to held, closing its index with the last one
+func (h *Holder) release(held *heldIndex) error {
+	h.mu.Lock()
+	held.refs--
+	done := held.refs == 0
+	h.mu.Unlock()
+	if done {
+		return held.idx.Close()
+	}
+	return nil
+}
+
+// Reload opens the index again if its manifest changed since it was last
+// opened, and reports whether a new index was swapped in. The current index
+// is kept when the new one fails to open. A directory in the middle of
+// being swapped by Create, without a manifest, is left for the next reload.
+func (h *Holder) Reload() (bool, error) {
+	fi, err := os.Stat(path.Join(h.dir, MANIFEST))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	h.mu.Lock()
+	unchanged := h.closed || sameFile(h.manifest, fi)
+	h.mu.Unlock()
+	if unchanged {
+		return false, nil
+	}
+
+	idx, err := Open(h.dir)
+
+	h.mu.Lock()
+	// a failed index is not retried until its manifest changes again
+	h.manifest = fi
+	if err != nil || h.closed {
+		h.mu.Unlock()
+		if idx != nil {
+			idx.Close()
+		}
+		return false, err
+	}
+	old := h.cur
+	h.cur = &heldIndex{idx: idx, refs: 1}
+	h.mu.Unlock()
+
+	h.release(old)
+	return true, nil
+}
+
+// sameFile reports whether a and b describe the same unmodified file
+func sameFile(a, b os.FileInfo) bool {
+	return a != nil && b != nil && os.SameFile(a, b) &&
+		a.ModTime().Equal(b.ModTime()) && a.Size() == b.Size()
+}
+
+// Watch reloads the index every interval until ctx is done, which it
+// returns. fn, when set, is called after every reload that swapped in a new
+// index or failed.
+func (h *Holder) Watch(ctx context.Context, interval time.Duration, fn func(*Manifest, error)) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		reloaded, err := h.Reload()
+		if fn == nil || (!reloaded && err == nil) {
+			continue
+		}
+		if err != nil {
+			fn(nil, err)
+			continue
+		}
+		idx, release := h.Acquire()
+		fn(idx.Manifest(), nil)
+		release()
+	}
+}
+
+// Close releases the held index, which is closed once every query using it
+// released it. Acquire must not be called after Close.
+func (h *Holder) Close() error {
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return nil
+	}
+	h.closed = true
+	held := h.cur
+	h.mu.Unlock()
+
+	return h.release(held)
+}
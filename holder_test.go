@@ -0,0 +1,147 @@
+package imdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jbpratt78/imdb-index/types"
+)
+
+func TestHolderReload(t *testing.T) {
+	dir, cleanup := copyIndex(t)
+	defer cleanup()
+	h, err := HolderOpen(dir)
+	if err != nil {
+		t.Fatalf("failed to open holder: %v", err)
+	}
+	defer h.Close()
+
+	if reloaded, err := h.Reload(); err != nil || reloaded {
+		t.Fatalf("expected an unchanged index to be kept: %v %v", reloaded, err)
+	}
+
+	old, release := h.Acquire()
+	idx, err := Create("testdata", dir)
+	if err != nil {
+		t.Fatalf("failed to rebuild index: %v", err)
+	}
+	idx.Close()
+
+	if reloaded, err := h.Reload(); err != nil || !reloaded {
+		t.Fatalf("expected the rebuilt index to be swapped in: %v %v", reloaded, err)
+	}
+	cur, releaseCur := h.Acquire()
+	defer releaseCur()
+	if cur == old || !cur.Manifest().BuiltAt.After(old.Manifest().BuiltAt) {
+		t.Fatalf("expected the rebuilt index, got the one built at %v", cur.Manifest().BuiltAt)
+	}
+
+	// the replaced index stays open for the query still using it
+	if title, err := old.Titles().Title([]byte("tt0096697")); err != nil || title.Title != "The Simpsons" {
+		t.Fatalf("failed to read the replaced index: %v", err)
+	}
+	h.mu.Lock()
+	refs := h.cur.refs
+	h.mu.Unlock()
+	if refs != 2 {
+		t.Fatalf("incorrect references to the current index: %d", refs)
+	}
+	// releasing twice drops a single reference
+	release()
+	release()
+}
+
+func TestHolderReloadFailed(t *testing.T) {
+	dir, cleanup := copyIndex(t)
+	defer cleanup()
+	h, err := HolderOpen(dir)
+	if err != nil {
+		t.Fatalf("failed to open holder: %v", err)
+	}
+	defer h.Close()
+	old, release := h.Acquire()
+	release()
+
+	data, err := ioutil.ReadFile(path.Join(dir, MANIFEST))
+	if err != nil {
+		t.Fatal(err)
+	}
+	version := fmt.Sprintf(`"format_version": %d`, IndexFormatVersion)
+	data = []byte(strings.Replace(string(data), version, `"format_version": 99`, 1))
+	if err = writeFileAtomic(path.Join(dir, MANIFEST), data); err != nil {
+		t.Fatal(err)
+	}
+
+	var ierr *IndexError
+	if _, err := h.Reload(); !errors.As(err, &ierr) {
+		t.Fatalf("expected an index error, got: %v", err)
+	}
+	// the failed index is not retried until it changes again
+	if reloaded, err := h.Reload(); err != nil || reloaded {
+		t.Fatalf("expected the failed index to be skipped: %v %v", reloaded, err)
+	}
+	if cur, release := h.Acquire(); cur != old {
+		t.Fatalf("expected the current index to be kept")
+	} else {
+		release()
+	}
+}
+
+func TestHolderWatch(t *testing.T) {
+	dir, cleanup := copyIndex(t)
+	defer cleanup()
+	h, err := HolderOpen(dir)
+	if err != nil {
+		t.Fatalf("failed to open holder: %v", err)
+	}
+	defer h.Close()
+
+	srv := httptest.NewServer(NewHolderServer(h))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	reloaded := make(chan *Manifest, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- h.Watch(ctx, 10*time.Millisecond, func(m *Manifest, err error) {
+			if err == nil {
+				reloaded <- m
+			}
+		})
+	}()
+
+	idx, err := Create("testdata", dir)
+	if err != nil {
+		t.Fatalf("failed to rebuild index: %v", err)
+	}
+	builtAt := idx.Manifest().BuiltAt
+	idx.Close()
+
+	select {
+	case m := <-reloaded:
+		if !m.BuiltAt.Equal(builtAt) {
+			t.Fatalf("reloaded the index built at %v, want %v", m.BuiltAt, builtAt)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("index was not reloaded")
+	}
+
+	var title types.Title
+	get(t, srv, "/titles/tt0096697", http.StatusOK, &title)
+	if title.Title != "The Simpsons" {
+		t.Fatalf("incorrect title: %+v", title)
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected cancellation, got: %v", err)
+	}
+}
@@ -0,0 +1,484 @@
+package imdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jbpratt78/imdb-index/types"
+	"golang.org/x/sync/errgroup"
+)
+
+// The errors of every index wrap one of these, so callers can tell a miss
+// from a broken index with errors.Is
+var (
+	// ErrNotFound is returned for ids an index does not hold.
+	ErrNotFound = errors.New("not found")
+	// ErrCorruptIndex is returned for index files that are incomplete or do
+	// not hold what they should.
+	ErrCorruptIndex = errors.New("corrupt index")
+	// ErrFormatVersion is returned for indices of another format version.
+	ErrFormatVersion = errors.New("unsupported index format version")
+	// ErrIndexDir is returned by Create for an index directory it refuses to
+	// replace, because it holds the datasets or files other than an index.
+	ErrIndexDir = errors.New("not an index directory")
+)
+
+// Index holds every sub-index of one index directory
+type Index struct {
+	manifest *Manifest
+	titles   *TitleIndex
+	akas     *AkasIndex
+	episodes *EpisodeIndex
+	ratings  *RatingsIndex
+	names    *NameIndex
+}
+
+// BuildOptions controls how an index is built
+type BuildOptions struct {
+	// Names configures the name index.
+	Names NameConfig
+	// Sort controls the external sort of datasets only available as `.gz`.
+	// Several datasets are sorted at once, each within the memory budget.
+	Sort SortOptions
+	// Progress, when set, receives reports of the rows parsed from every
+	// dataset and the keys inserted into every index file.
+	Progress ProgressFunc
+}
+
+// Create builds every sub-index from the TSV files in dataDir into indexDir
+// and opens them. Datasets missing from dataDir are read from their `.gz`
+// instead. The index does not depend on dataDir once built.
+//
+// indexDir is a symlink to a directory next to it holding the index, named
+// after it with a `.version-` suffix. The index is built in a staging
+// directory, which the symlink is switched to with a single rename only once
+// it is complete and described by its manifest, so indexDir never holds a
+// mix of two indices or nothing at all. A failed build leaves indexDir as it
+// was. An existing indexDir is only replaced when it is empty or holds a
+// manifest, and never when it holds dataDir. Builds of the same indexDir
+// must not run at once: a build removes the staging directories left by
+// interrupted ones.
+func Create(dataDir, indexDir string) (*Index, error) {
+	return CreateWithConfig(dataDir, indexDir, DefaultNameConfig)
+}
+
+// CreateWithConfig is Create with the given name index configuration
+func CreateWithConfig(dataDir, indexDir string, cfg NameConfig) (*Index, error) {
+	return CreateContext(context.Background(), dataDir, indexDir, BuildOptions{Names: cfg, Sort: DefaultSortOptions})
+}
+
+// CreateContext is Create with the given options, stopping early with the
+// context's error once ctx is done
+func CreateContext(ctx context.Context, dataDir, indexDir string, opts BuildOptions) (*Index, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	indexDir = filepath.Clean(indexDir)
+	if err := checkIndexDir(dataDir, indexDir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(indexDir), os.ModePerm); err != nil {
+		return nil, err
+	}
+	if err := recoverIndexDir(indexDir); err != nil {
+		return nil, err
+	}
+	if err := removeStale(indexDir); err != nil {
+		return nil, err
+	}
+	prefix := filepath.Base(indexDir) + stagingSuffix
+	staging, err := ioutil.TempDir(filepath.Dir(indexDir), prefix)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Chmod(staging, 0755); err != nil {
+		os.RemoveAll(staging)
+		return nil, err
+	}
+
+	if err = build(ctx, dataDir, staging, opts); err != nil {
+		os.RemoveAll(staging)
+		return nil, err
+	}
+	version := indexDir + versionSuffix + strings.TrimPrefix(filepath.Base(staging), prefix)
+	if err = os.Rename(staging, version); err != nil {
+		os.RemoveAll(staging)
+		return nil, err
+	}
+	if err = swapDir(version, indexDir); err != nil {
+		os.RemoveAll(version)
+		return nil, err
+	}
+	return Open(indexDir)
+}
+
+// build builds every sub-index from dataDir into dir and writes their
+// manifest
+func build(ctx context.Context, dataDir, dir string, opts BuildOptions) error {
+	// every builder writes its own outputs, so they can all run at once
+	idx := &Index{}
+	// the first failure stops the other builders
+	errs, gctx := errgroup.WithContext(ctx)
+	src := newDataSource(newTracker(gctx, opts.Progress), dataDir, opts.Sort)
+	// the title and name builders both read the basics, and the akas and
+	// name builders the akas, so each compressed dataset is sorted once
+	// into a directory removed before the index is swapped in
+	tmp, err := ioutil.TempDir(dir, "sorted-")
+	if err != nil {
+		return err
+	}
+	src.tmp = tmp
+	errs.Go(func() (err error) {
+		if idx.titles, err = titleCreate(src, dir); err != nil {
+			return fmt.Errorf("failed to build title index: %w", err)
+		}
+		return nil
+	})
+	errs.Go(func() (err error) {
+		if idx.akas, err = akasCreate(src, dir); err != nil {
+			return fmt.Errorf("failed to build akas index: %w", err)
+		}
+		return nil
+	})
+	errs.Go(func() (err error) {
+		if idx.episodes, err = episodeCreate(src, dir); err != nil {
+			return fmt.Errorf("failed to build episode index: %w", err)
+		}
+		return nil
+	})
+	errs.Go(func() (err error) {
+		if idx.ratings, err = ratingsCreate(src, dir); err != nil {
+			return fmt.Errorf("failed to build ratings index: %w", err)
+		}
+		return nil
+	})
+	errs.Go(func() (err error) {
+		if idx.names, err = nameCreate(src, dir, opts.Names); err != nil {
+			return fmt.Errorf("failed to build name index: %w", err)
+		}
+		return nil
+	})
+	err = errs.Wait()
+	if rerr := os.RemoveAll(tmp); err == nil {
+		err = rerr
+	}
+	if err != nil {
+		idx.Close()
+		// the builders wrap errors in their own types, so report a
+		// cancellation as such
+		if cerr := ctx.Err(); cerr != nil {
+			return cerr
+		}
+		return err
+	}
+	defer idx.Close()
+
+	m, err := newManifest(dir, idx, src)
+	if err != nil {
+		return fmt.Errorf("failed to describe index: %w", err)
+	}
+	return m.write(dir)
+}
+
+// checkIndexDir refuses an indexDir that is dataDir, holds it, or could not
+// be replaced by swapDir
+func checkIndexDir(dataDir, indexDir string) error {
+	data, err := filepath.Abs(dataDir)
+	if err != nil {
+		return err
+	}
+	index, err := filepath.Abs(indexDir)
+	if err != nil {
+		return err
+	}
+	if rel, err := filepath.Rel(index, data); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%s holds the data directory %s: %w", indexDir, dataDir, ErrIndexDir)
+	}
+	// the same directory by another path, e.g. through a symlink
+	fi, err := os.Stat(indexDir)
+	if err == nil {
+		if di, err := os.Stat(dataDir); err == nil && os.SameFile(fi, di) {
+			return fmt.Errorf("%s is the data directory: %w", indexDir, ErrIndexDir)
+		}
+	}
+	return replaceable(indexDir)
+}
+
+// replaceable returns an error unless dir is missing, empty or holds a
+// manifest, since replacing it removes every file it holds
+func replaceable(dir string) error {
+	fi, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory: %w", dir, ErrIndexDir)
+	}
+	if _, err = os.Stat(filepath.Join(dir, MANIFEST)); err == nil {
+		return nil
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	if len(files) > 0 {
+		return fmt.Errorf("%s is not empty and holds no %s: %w", dir, MANIFEST, ErrIndexDir)
+	}
+	return nil
+}
+
+// The siblings of an index directory: the versions its symlink points at,
+// the staging directories of builds and, only while an index directory from
+// before they were versioned is replaced, the directory moved aside.
+const (
+	versionSuffix = ".version-"
+	stagingSuffix = ".staging-"
+	oldSuffix     = ".old"
+)
+
+// swapDir points dir at version, a directory next to it, by renaming a new
+// symlink over it. The version dir pointed at before is removed, while open
+// indices keep reading its files until they are closed. A dir that is a
+// directory rather than a symlink cannot be replaced by a rename, so it is
+// moved aside first and recovered by recoverIndexDir if the swap does not
+// complete. Only a dir accepted by replaceable is replaced.
+func swapDir(version, dir string) error {
+	if err := replaceable(dir); err != nil {
+		return err
+	}
+	fi, err := os.Lstat(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	exists := err == nil
+
+	var prev string
+	if exists && fi.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(dir)
+		if err != nil {
+			return err
+		}
+		prev = ownVersion(dir, target)
+	}
+
+	link := dir + ".link"
+	os.Remove(link)
+	if err = os.Symlink(filepath.Base(version), link); err != nil {
+		return err
+	}
+
+	if exists && fi.IsDir() {
+		old := dir + oldSuffix
+		if err = os.Rename(dir, old); err != nil {
+			os.Remove(link)
+			return err
+		}
+		if err = os.Rename(link, dir); err != nil {
+			os.Rename(old, dir)
+			os.Remove(link)
+			return err
+		}
+		return os.RemoveAll(old)
+	}
+
+	if err = os.Rename(link, dir); err != nil {
+		os.Remove(link)
+		return err
+	}
+	if prev != "" {
+		// a version left behind is removed by the next build
+		os.RemoveAll(prev)
+	}
+	return nil
+}
+
+// ownVersion returns the path of the version directory the symlink dir
+// points at, or the empty string if target is not one of its versions
+func ownVersion(dir, target string) string {
+	if target != filepath.Base(target) || !strings.HasPrefix(target, filepath.Base(dir)+versionSuffix) {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(dir), target)
+}
+
+// recoverIndexDir puts back an index directory moved aside by a swapDir that
+// did not complete, or removes it if the swap completed
+func recoverIndexDir(dir string) error {
+	old := dir + oldSuffix
+	if _, err := os.Lstat(old); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if _, err := os.Lstat(dir); os.IsNotExist(err) {
+		return os.Rename(old, dir)
+	} else if err != nil {
+		return err
+	}
+	return os.RemoveAll(old)
+}
+
+// removeStale removes the staging directories of interrupted builds of dir
+// and the versions it no longer points at
+func removeStale(dir string) error {
+	current := ""
+	if target, err := os.Readlink(dir); err == nil {
+		current = ownVersion(dir, target)
+	}
+	files, err := ioutil.ReadDir(filepath.Dir(dir))
+	if err != nil {
+		return err
+	}
+	base := filepath.Base(dir)
+	for _, fi := range files {
+		name := filepath.Join(filepath.Dir(dir), fi.Name())
+		if !strings.HasPrefix(fi.Name(), base+stagingSuffix) && !strings.HasPrefix(fi.Name(), base+versionSuffix) {
+			continue
+		}
+		if name == current {
+			continue
+		}
+		if err = os.RemoveAll(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveIndexDir returns the directory indexDir points at, so that its
+// files are all opened from the same version even if a build swaps in
+// another meanwhile
+func resolveIndexDir(indexDir string) (string, error) {
+	if err := recoverIndexDir(indexDir); err != nil {
+		return "", err
+	}
+	dir, err := filepath.EvalSymlinks(indexDir)
+	if os.IsNotExist(err) {
+		// reported as a missing manifest
+		return indexDir, nil
+	}
+	return dir, err
+}
+
+// Open opens every sub-index of an index previously built by Create. It
+// refuses an index without a manifest, of another format version, or whose
+// files do not match the manifest.
+func Open(indexDir string) (*Index, error) {
+	indexDir, err := resolveIndexDir(indexDir)
+	if err != nil {
+		return nil, err
+	}
+	m, err := readManifest(indexDir)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := &Index{manifest: m}
+	if idx.titles, err = titleOpen(indexDir); err != nil {
+		return nil, fmt.Errorf("failed to open title index: %w", err)
+	}
+	if idx.akas, err = akasOpen(indexDir); err != nil {
+		idx.Close()
+		return nil, fmt.Errorf("failed to open akas index: %w", err)
+	}
+	if idx.episodes, err = episodeOpen(indexDir); err != nil {
+		idx.Close()
+		return nil, fmt.Errorf("failed to open episode index: %w", err)
+	}
+	if idx.ratings, err = ratingsOpen(indexDir); err != nil {
+		idx.Close()
+		return nil, fmt.Errorf("failed to open ratings index: %w", err)
+	}
+	if idx.names, err = nameOpen(indexDir); err != nil {
+		idx.Close()
+		return nil, fmt.Errorf("failed to open name index: %w", err)
+	}
+	if err = m.checkRecords(idx); err != nil {
+		idx.Close()
+		return nil, err
+	}
+	return idx, nil
+}
+
+// Manifest returns the manifest the index was opened with
+func (i *Index) Manifest() *Manifest { return i.manifest }
+
+// Titles returns the title index
+func (i *Index) Titles() *TitleIndex { return i.titles }
+
+// Akas returns the alternate names index
+func (i *Index) Akas() *AkasIndex { return i.akas }
+
+// Episodes returns the episode index
+func (i *Index) Episodes() *EpisodeIndex { return i.episodes }
+
+// Ratings returns the ratings index
+func (i *Index) Ratings() *RatingsIndex { return i.ratings }
+
+// Names returns the name index
+func (i *Index) Names() *NameIndex { return i.names }
+
+// Searcher returns a searcher over the sub-indices
+func (i *Index) Searcher() *Searcher {
+	return NewSearcher(i.titles, i.names, i.ratings, i.episodes)
+}
+
+// RatedTitle is a title with its rating
+type RatedTitle struct {
+	Title  *types.Title  `json:"title"`
+	Rating *types.Rating `json:"rating"`
+}
+
+// TopRated returns the titles selected by q in the order of RatingsIndex.Top,
+// restricted to the kinds of q.Kinds. Rated titles missing from the title
+// index are skipped.
+func (i *Index) TopRated(q RatingQuery) ([]*RatedTitle, error) {
+	var rated []*RatedTitle
+	err := i.ratings.rankRange(q, func(r *types.Rating) (bool, error) {
+		title, ok, err := i.titles.Lookup([]byte(r.Id))
+		if err != nil || !ok || !matchesKind(q.Kinds, title.Kind) {
+			return false, err
+		}
+		rated = append(rated, &RatedTitle{title, r})
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rated, nil
+}
+
+// Close releases every FST and memory map of the sub-indices that are open
+func (i *Index) Close() error {
+	var first error
+	keep := func(err error) {
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	if i.titles != nil {
+		keep(i.titles.Close())
+	}
+	if i.akas != nil {
+		keep(i.akas.Close())
+	}
+	if i.episodes != nil {
+		keep(i.episodes.Close())
+	}
+	if i.ratings != nil {
+		keep(i.ratings.Close())
+	}
+	if i.names != nil {
+		keep(i.names.Close())
+	}
+	return first
+}
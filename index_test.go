@@ -0,0 +1,311 @@
+package imdb
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/jbpratt78/imdb-index/types"
+)
+
+// index gets setup in episode_test.go:TestMain
+func TestIndexOpen(t *testing.T) {
+	idx, err := Open(tmpDir)
+	if err != nil {
+		t.Fatalf("failed to open index: %v", err)
+	}
+
+	if _, err := idx.Titles().Title([]byte("tt0096697")); err != nil {
+		t.Fatalf("failed to get title: %v", err)
+	}
+	if _, err := idx.Akas().Find([]byte("tt0096697")); err != nil {
+		t.Fatalf("failed to find akas: %v", err)
+	}
+	if _, err := idx.Episodes().Episode([]byte("tt0701063")); err != nil {
+		t.Fatalf("failed to get episode: %v", err)
+	}
+	if _, err := idx.Ratings().Rating([]byte("tt0096697")); err != nil {
+		t.Fatalf("failed to get rating: %v", err)
+	}
+	if _, err := idx.Names().Search(&NameQuery{Name: "simpsons"}); err != nil {
+		t.Fatalf("failed to search names: %v", err)
+	}
+
+	if err := idx.Close(); err != nil {
+		t.Fatalf("failed to close index: %v", err)
+	}
+}
+
+func TestIndexOpenMissing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imdb-index-empty")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := Open(dir); err == nil {
+		t.Fatalf("expected error opening an empty directory")
+	}
+}
+
+func TestCreateContextProgress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imdb-index-progress")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	dir = path.Join(dir, "index")
+
+	var mu sync.Mutex
+	finished := map[string]Progress{}
+	opts := BuildOptions{Names: DefaultNameConfig, Progress: func(p Progress) {
+		mu.Lock()
+		defer mu.Unlock()
+		if p.Finished {
+			finished[p.Name+" "+string(p.Stage)] = p
+		}
+	}}
+	idx, err := CreateContext(context.Background(), "testdata", dir, opts)
+	if err != nil {
+		t.Fatalf("failed to build index: %v", err)
+	}
+	idx.Close()
+
+	for _, name := range []string{IMDBBasics, IMDBAKAS, IMDBEpisode, IMDBRatings, NAMES} {
+		if p, ok := finished[name+" "+string(StageParse)]; !ok || p.Count == 0 {
+			t.Fatalf("expected rows of %s to be parsed, got %+v", name, p)
+		}
+	}
+	for _, name := range []string{TITLES, AKAS, SEASONS, TVSHOWS, RATINGS, NAMES} {
+		p, ok := finished[name+" "+string(StageInsert)]
+		if !ok || p.Count == 0 || p.Count != p.Total {
+			t.Fatalf("expected every key of %s to be inserted, got %+v", name, p)
+		}
+	}
+}
+
+func TestCreateContextCancelled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imdb-index-cancelled")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := CreateContext(ctx, "testdata", dir, BuildOptions{Names: DefaultNameConfig}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the build to be cancelled, got %v", err)
+	}
+}
+
+func TestCreateFromCompressed(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "imdb-index-data")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dataDir)
+	indexDir, err := ioutil.TempDir("", "imdb-index-compressed")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(indexDir)
+	indexDir = path.Join(indexDir, "index")
+
+	writeCompressedDatasets(t, dataDir)
+	idx, err := Create(dataDir, indexDir)
+	if err != nil {
+		t.Fatalf("failed to build index: %v", err)
+	}
+	idx.Close()
+
+	// the sorted datasets are not left in the index
+	files, err := ioutil.ReadDir(indexDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fi := range files {
+		if fi.IsDir() {
+			t.Fatalf("unexpected directory %s in the index", fi.Name())
+		}
+	}
+
+	// the index is self contained
+	os.RemoveAll(dataDir)
+	if idx, err = Open(indexDir); err != nil {
+		t.Fatalf("failed to open index: %v", err)
+	}
+	defer idx.Close()
+	want, err := Open(tmpDir)
+	if err != nil {
+		t.Fatalf("failed to open index: %v", err)
+	}
+	defer want.Close()
+
+	title, err := idx.Titles().Title([]byte("tt0701063"))
+	if err != nil {
+		t.Fatalf("failed to get title: %v", err)
+	}
+	wantTitle, _ := want.Titles().Title([]byte("tt0701063"))
+	if !reflect.DeepEqual(title, wantTitle) {
+		t.Fatalf("incorrect title: got=%+v want=%+v", title, wantTitle)
+	}
+
+	akas, err := idx.Akas().Find([]byte("tt0096697"))
+	if err != nil {
+		t.Fatalf("failed to find akas: %v", err)
+	}
+	wantAkas, _ := want.Akas().Find([]byte("tt0096697"))
+	// the test data orders akas as text rather than by number
+	sort.Slice(wantAkas, func(i, j int) bool { return wantAkas[i].Order < wantAkas[j].Order })
+	if !reflect.DeepEqual(akas, wantAkas) {
+		t.Fatalf("incorrect akas: got=%+v want=%+v", akas, wantAkas)
+	}
+
+	results, err := idx.Searcher().Search(&types.Query{Name: "simpsons", Size: 5})
+	if err != nil || len(results) == 0 {
+		t.Fatalf("failed to search: %v %v", results, err)
+	}
+}
+
+func TestCreateReplacesIndex(t *testing.T) {
+	parent, err := ioutil.TempDir("", "imdb-index-swap")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(parent)
+	dir := path.Join(parent, "index")
+
+	idx, err := Create("testdata", dir)
+	if err != nil {
+		t.Fatalf("failed to build index: %v", err)
+	}
+	idx.Close()
+	built := idx.Manifest().BuiltAt
+
+	// a failed build leaves the index as it was
+	if _, err = Create(path.Join(parent, "missing"), dir); err == nil {
+		t.Fatalf("expected the build to fail")
+	}
+	if idx, err = Open(dir); err != nil {
+		t.Fatalf("failed to open the previous index: %v", err)
+	}
+	idx.Close()
+	if !idx.Manifest().BuiltAt.Equal(built) {
+		t.Fatalf("expected the previous index to be kept")
+	}
+
+	if idx, err = Create("testdata", dir); err != nil {
+		t.Fatalf("failed to rebuild index: %v", err)
+	}
+	idx.Close()
+	if idx.Manifest().BuiltAt.Equal(built) {
+		t.Fatalf("expected the index to be replaced")
+	}
+
+	// nothing but the index and the version it links to is left behind
+	files, err := ioutil.ReadDir(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, err := os.Readlink(dir)
+	if err != nil {
+		t.Fatalf("expected the index to be a link: %v", err)
+	}
+	if len(files) != 2 || files[0].Name() != "index" || files[1].Name() != target {
+		t.Fatalf("expected only the index and %s, got %d entries", target, len(files))
+	}
+}
+
+func TestCreateRecoversInterruptedSwap(t *testing.T) {
+	parent, err := ioutil.TempDir("", "imdb-index-recover")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(parent)
+	dir := path.Join(parent, "index")
+
+	// an index of an earlier release, moved aside by a swap that was
+	// interrupted before its replacement was renamed into place
+	files, err := ioutil.ReadDir(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := dir + oldSuffix
+	if err = os.Mkdir(old, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, fi := range files {
+		data, err := ioutil.ReadFile(path.Join(tmpDir, fi.Name()))
+		if err == nil {
+			err = ioutil.WriteFile(path.Join(old, fi.Name()), data, 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	staging := path.Join(parent, "index"+stagingSuffix+"123")
+	if err = os.Mkdir(staging, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	idx, err := Open(dir)
+	if err != nil {
+		t.Fatalf("failed to open the restored index: %v", err)
+	}
+	idx.Close()
+	if fi, err := os.Lstat(dir); err != nil || !fi.IsDir() {
+		t.Fatalf("expected the index directory to be restored: %v", err)
+	}
+
+	if idx, err = Create("testdata", dir); err != nil {
+		t.Fatalf("failed to rebuild index: %v", err)
+	}
+	idx.Close()
+	for _, leftover := range []string{old, staging} {
+		if _, err = os.Lstat(leftover); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be removed: %v", leftover, err)
+		}
+	}
+}
+
+func TestCreateRefusesForeignDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imdb-index-foreign")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	notes := path.Join(dir, "notes.txt")
+	if err = ioutil.WriteFile(notes, []byte("keep me"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = Create("testdata", dir); !errors.Is(err, ErrIndexDir) {
+		t.Fatalf("expected an index directory error, got %v", err)
+	}
+	if data, err := ioutil.ReadFile(notes); err != nil || string(data) != "keep me" {
+		t.Fatalf("expected the foreign file to survive: %q %v", data, err)
+	}
+
+	// the datasets are never replaced, even next to a manifest
+	data := path.Join(dir, "data")
+	if err = os.Mkdir(data, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(path.Join(dir, MANIFEST), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, dataDir := range []string{dir, data} {
+		if _, err = Create(dataDir, dir); !errors.Is(err, ErrIndexDir) {
+			t.Fatalf("expected an index directory error building from %s, got %v", dataDir, err)
+		}
+	}
+	if _, err = os.Stat(notes); err != nil {
+		t.Fatalf("expected the foreign file to survive: %v", err)
+	}
+}
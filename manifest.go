@@ -0,0 +1,176 @@
+package imdb
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+)
+
+// MANIFEST describes a complete index. Create writes it last, and Open
+// refuses an index directory without one.
+const MANIFEST = "manifest.json"
+
+// IndexFormatVersion is the version of the index files written by Create.
+// It is bumped whenever their layout changes, and Open refuses indices of
+// any other version.
+const IndexFormatVersion = 5
+
+// IndexError is an index directory that cannot be opened. It wraps
+// ErrCorruptIndex or ErrFormatVersion.
+type IndexError struct {
+	Err error
+	msg string
+}
+
+func (e *IndexError) Error() string { return e.msg }
+
+func (e *IndexError) Unwrap() error { return e.Err }
+
+func indexError(err error, format string, args ...interface{}) error {
+	return &IndexError{err, fmt.Sprintf(format, args...)}
+}
+
+// Manifest records how an index was built
+type Manifest struct {
+	FormatVersion int       `json:"format_version"`
+	BuiltAt       time.Time `json:"built_at"`
+	// The datasets the index was built from, by name, e.g. IMDBBasics.
+	Datasets map[string]DatasetInfo `json:"datasets"`
+	// Every file of the index, by name, e.g. TITLES.
+	Files map[string]FileInfo `json:"files"`
+}
+
+// DatasetInfo identifies a dataset an index was built from
+type DatasetInfo struct {
+	// The file read, the TSV or its `.gz`.
+	File   string `json:"file"`
+	SHA256 string `json:"sha256"`
+}
+
+// FileInfo describes a file of an index
+type FileInfo struct {
+	Size int64 `json:"size"`
+	// The number of keys of an FST, rows of a record store, documents of
+	// the name documents or ngrams of the name postings.
+	Records int64  `json:"records"`
+	SHA256  string `json:"sha256"`
+}
+
+// newManifest describes the index built in dir from src
+func newManifest(dir string, idx *Index, src *dataSource) (*Manifest, error) {
+	m := &Manifest{
+		FormatVersion: IndexFormatVersion,
+		BuiltAt:       time.Now().UTC(),
+		Datasets:      src.checksums(),
+		Files:         map[string]FileInfo{},
+	}
+
+	records := idx.records()
+	records[NAMESCONFIG] = 0
+	for name := range records {
+		sum, size, err := hashFile(path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		m.Files[name] = FileInfo{Size: size, Records: records[name], SHA256: sum}
+	}
+	return m, nil
+}
+
+// records returns the number of records of every index file they can be
+// counted for without reading it
+func (i *Index) records() map[string]int64 {
+	return map[string]int64{
+		TITLES:        int64(i.titles.idx.Len()),
+		TITLERECORDS:  i.titles.data.records,
+		AKAS:          int64(i.akas.idx.Len()),
+		AKARECORDS:    i.akas.data.records,
+		SEASONS:       int64(i.episodes.seasons.Len()),
+		TVSHOWS:       int64(i.episodes.tvshows.Len()),
+		RATINGS:       int64(i.ratings.idx.Len()),
+		RATINGSRANK:   int64(i.ratings.rank.Len()),
+		NAMES:         int64(i.names.idx.Len()),
+		NAMESPOSTINGS: int64(i.names.idx.Len()),
+		NAMESDOCS:     int64(i.names.numDocs),
+	}
+}
+
+// hashFile returns the hex SHA-256 and size of a file
+func hashFile(name string) (string, int64, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.CopyBuffer(h, f, make([]byte, 1<<20))
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+func (m *Manifest) write(dir string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path.Join(dir, MANIFEST), append(data, '\n'))
+}
+
+// readManifest reads the manifest of dir and checks that the index it
+// describes can be opened: that it is of the current format and that every
+// file is present with its recorded size
+func readManifest(dir string) (*Manifest, error) {
+	data, err := ioutil.ReadFile(path.Join(dir, MANIFEST))
+	if os.IsNotExist(err) {
+		return nil, indexError(ErrCorruptIndex, "%s has no %s: the index is incomplete or was built by an older version, rebuild it", dir, MANIFEST)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var m Manifest
+	if err = json.Unmarshal(data, &m); err != nil {
+		return nil, indexError(ErrCorruptIndex, "failed to read %s of %s: %v", MANIFEST, dir, err)
+	}
+	if m.FormatVersion != IndexFormatVersion {
+		return nil, indexError(ErrFormatVersion, "index %s has format version %d, but version %d is required: rebuild it", dir, m.FormatVersion, IndexFormatVersion)
+	}
+
+	for name, info := range m.Files {
+		fi, err := os.Stat(path.Join(dir, name))
+		if os.IsNotExist(err) {
+			return nil, indexError(ErrCorruptIndex, "index %s is incomplete: %s is missing", dir, name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if fi.Size() != info.Size {
+			return nil, indexError(ErrCorruptIndex, "index %s is incomplete: %s has %d bytes, %d expected", dir, name, fi.Size(), info.Size)
+		}
+	}
+	return &m, nil
+}
+
+// checkRecords compares the records counted by an open index with the
+// manifest
+func (m *Manifest) checkRecords(idx *Index) error {
+	for name, n := range idx.records() {
+		info, ok := m.Files[name]
+		if !ok {
+			return indexError(ErrCorruptIndex, "%s does not list %s", MANIFEST, name)
+		}
+		if info.Records != n {
+			return indexError(ErrCorruptIndex, "%s has %d records, %d expected", name, n, info.Records)
+		}
+	}
+	return nil
+}
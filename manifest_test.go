@@ -0,0 +1,138 @@
+package imdb
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// copyIndex copies the index built in TestMain to a new directory and
+// returns it with the function removing it, along with the versions a build
+// into it leaves next to it
+func copyIndex(t *testing.T) (string, func()) {
+	parent, err := ioutil.TempDir("", "imdb-index-copy")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	cleanup := func() { os.RemoveAll(parent) }
+	dir := path.Join(parent, "index")
+	if err = os.Mkdir(dir, 0755); err != nil {
+		cleanup()
+		t.Fatalf("failed to create index dir: %v", err)
+	}
+
+	files, err := ioutil.ReadDir(tmpDir)
+	if err != nil {
+		cleanup()
+		t.Fatalf("failed to list index: %v", err)
+	}
+	for _, fi := range files {
+		data, err := ioutil.ReadFile(path.Join(tmpDir, fi.Name()))
+		if err == nil {
+			err = ioutil.WriteFile(path.Join(dir, fi.Name()), data, 0644)
+		}
+		if err != nil {
+			cleanup()
+			t.Fatalf("failed to copy index file: %v", err)
+		}
+	}
+	return dir, cleanup
+}
+
+func TestManifest(t *testing.T) {
+	idx, err := Open(tmpDir)
+	if err != nil {
+		t.Fatalf("failed to open index: %v", err)
+	}
+	defer idx.Close()
+	m := idx.Manifest()
+
+	if m.FormatVersion != IndexFormatVersion || m.BuiltAt.IsZero() {
+		t.Fatalf("incorrect manifest header: %+v", m)
+	}
+
+	data, err := ioutil.ReadFile(path.Join("testdata", IMDBBasics))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	want := DatasetInfo{File: IMDBBasics, SHA256: hex.EncodeToString(sum[:])}
+	if m.Datasets[IMDBBasics] != want {
+		t.Fatalf("incorrect basics checksum: got=%+v want=%+v", m.Datasets[IMDBBasics], want)
+	}
+	if len(m.Datasets) != 4 {
+		t.Fatalf("expected every dataset to be listed: %+v", m.Datasets)
+	}
+
+	if got := m.Files[AKARECORDS].Records; got != 38 {
+		t.Fatalf("incorrect number of aka records: %d", got)
+	}
+	if got := m.Files[AKAS].Records; got != 1 {
+		t.Fatalf("incorrect number of akas keys: %d", got)
+	}
+	for _, name := range []string{TITLES, TITLERECORDS, SEASONS, TVSHOWS, RATINGS, NAMES, NAMESPOSTINGS, NAMESDOCS, NAMESCONFIG} {
+		info, ok := m.Files[name]
+		if !ok || info.Size == 0 || len(info.SHA256) != 64 {
+			t.Fatalf("incorrect manifest entry for %s: %+v", name, info)
+		}
+	}
+}
+
+func TestOpenWithoutManifest(t *testing.T) {
+	dir, cleanup := copyIndex(t)
+	defer cleanup()
+	os.Remove(path.Join(dir, MANIFEST))
+
+	_, err := Open(dir)
+	var ierr *IndexError
+	if !errors.As(err, &ierr) || !strings.Contains(err.Error(), "rebuild") {
+		t.Fatalf("expected an index error, got %v", err)
+	}
+}
+
+func TestOpenFormatVersion(t *testing.T) {
+	dir, cleanup := copyIndex(t)
+	defer cleanup()
+	data, err := ioutil.ReadFile(path.Join(dir, MANIFEST))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]interface{}
+	json.Unmarshal(data, &m)
+	m["format_version"] = IndexFormatVersion + 1
+	data, _ = json.Marshal(m)
+	ioutil.WriteFile(path.Join(dir, MANIFEST), data, 0644)
+
+	_, err = Open(dir)
+	var ierr *IndexError
+	if !errors.As(err, &ierr) || !errors.Is(err, ErrFormatVersion) || !strings.Contains(err.Error(), "format version") {
+		t.Fatalf("expected a format version error, got %v", err)
+	}
+}
+
+func TestOpenIncomplete(t *testing.T) {
+	dir, cleanup := copyIndex(t)
+	defer cleanup()
+	data, err := ioutil.ReadFile(path.Join(dir, SEASONS))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(path.Join(dir, SEASONS), data[:len(data)/2], 0644)
+
+	_, err = Open(dir)
+	var ierr *IndexError
+	if !errors.As(err, &ierr) || !errors.Is(err, ErrCorruptIndex) || !strings.Contains(err.Error(), SEASONS) {
+		t.Fatalf("expected an incomplete index error, got %v", err)
+	}
+
+	os.Remove(path.Join(dir, SEASONS))
+	if _, err = Open(dir); !errors.As(err, &ierr) {
+		t.Fatalf("expected an incomplete index error, got %v", err)
+	}
+}
@@ -0,0 +1,825 @@
+package imdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/couchbase/vellum"
+	"golang.org/x/exp/mmap"
+)
+
+const (
+	NAMES         = "names.fst"
+	NAMESPOSTINGS = "names.postings"
+	NAMESDOCS     = "names.docs"
+	NAMESCONFIG   = "names.json"
+)
+
+type NameError string
+
+func (e NameError) Error() string { return string(e) }
+
+// NgramType determines how names are split into ngrams
+type NgramType string
+
+const (
+	// NgramWindow slides a window of the ngram size over the whole name
+	NgramWindow NgramType = "window"
+	// NgramEdge takes the prefixes of every word in the name, from two
+	// characters up to the ngram size
+	NgramEdge NgramType = "edge"
+)
+
+// ParseNgramType returns the NgramType with the given name
+func ParseNgramType(name string) (NgramType, error) {
+	switch t := NgramType(name); t {
+	case NgramWindow, NgramEdge:
+		return t, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrorUnknownNgramType, name)
+}
+
+// NameConfig controls how names are tokenized by the name index. The same
+// configuration is used when querying, so it is stored alongside the index.
+type NameConfig struct {
+	NgramType NgramType `json:"ngram_type"`
+	NgramSize int       `json:"ngram_size"`
+}
+
+// DefaultNameConfig is the configuration used when none is given
+var DefaultNameConfig = NameConfig{NgramType: NgramWindow, NgramSize: 3}
+
+type nameMeta struct {
+	NameConfig
+	NumDocs uint64  `json:"num_docs"`
+	AvgLen  float64 `json:"avg_len"`
+}
+
+// NameIndex is an inverted index from name ngrams to the titles carrying
+// those names. Every distinct name of a title (primary, original and each
+// alternate name) is a separate document.
+//
+// The ngram FST maps each ngram to its postings list in the postings file. A
+// postings list is a count followed by (document, frequency) pairs. The
+// documents file holds the title id and name of every document followed by
+// a table of their offsets and a table of the number of ngrams of every
+// document, so documents are scored by number without reading them.
+type NameIndex struct {
+	meta     nameMeta
+	idx      *vellum.FST
+	postings *mmap.ReaderAt
+	docs     *mmap.ReaderAt
+	numDocs  uint64
+	docTable int64
+	lenTable int64
+}
+
+// NameQuery is a search against the name index
+type NameQuery struct {
+	// The name to search for.
+	Name string
+	// The maximum number of titles to return. Defaults to 30.
+	Size int
+	// The scorer ranking the candidates. Defaults to DefaultNameScorer.
+	Scorer NameScorer
+	// The similarity function re-ranking the best candidates against the
+	// query. Defaults to SimilarityNone, which keeps the scorer's ranking.
+	Similarity Similarity
+}
+
+// NameResult is a single title matching a NameQuery
+type NameResult struct {
+	// The IMDb identifier of the title.
+	Id string
+	// The name of the title that matched the query best.
+	Name string
+	// The relevance of the match, higher is better. When the query has a
+	// similarity function this is the similarity of the name to the query.
+	Score float64
+}
+
+// rerankWindow is how many times the requested size of candidates is
+// re-ranked by similarity
+const rerankWindow = 3
+
+type nameDoc struct {
+	id   string
+	name string
+	// the number of ngrams of the name
+	len uint32
+}
+
+// nameCandidate is a scored document of a search
+type nameCandidate struct {
+	doc   uint32
+	len   uint32
+	score float64
+}
+
+type posting struct {
+	doc  uint32
+	freq uint32
+}
+
+// nameOpen opens the files of its index in indexDir, which Open checks
+// against the manifest first
+func nameOpen(indexDir string) (*NameIndex, error) {
+	f, err := os.Open(path.Join(indexDir, NAMESCONFIG))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var meta nameMeta
+	if err = json.NewDecoder(f).Decode(&meta); err != nil {
+		return nil, NameError(fmt.Sprintf("failed to read name config: %v", err))
+	}
+
+	idx, err := fstSetFile(path.Join(indexDir, NAMES))
+	if err != nil {
+		return nil, err
+	}
+
+	postings, err := mmap.Open(path.Join(indexDir, NAMESPOSTINGS))
+	if err != nil {
+		idx.Close()
+		return nil, err
+	}
+
+	docs, err := mmap.Open(path.Join(indexDir, NAMESDOCS))
+	if err != nil {
+		closeAll(idx, postings)
+		return nil, err
+	}
+
+	n := &NameIndex{meta: meta, idx: idx, postings: postings, docs: docs}
+	if err = n.readDocTable(); err != nil {
+		n.Close()
+		return nil, err
+	}
+	return n, nil
+}
+
+func (n *NameIndex) readDocTable() error {
+	if n.docs.Len() < 8 {
+		return fmt.Errorf("name documents file is truncated: %w", ErrCorruptIndex)
+	}
+	buf := make([]byte, 8)
+	if _, err := n.docs.ReadAt(buf, int64(n.docs.Len()-8)); err != nil {
+		return err
+	}
+	n.numDocs = binary.BigEndian.Uint64(buf)
+	if n.numDocs != n.meta.NumDocs || n.numDocs > uint64(n.docs.Len())/12 {
+		return fmt.Errorf("name documents file does not match its config: %w", ErrCorruptIndex)
+	}
+	n.lenTable = int64(n.docs.Len()) - 8 - int64(n.numDocs)*4
+	n.docTable = n.lenTable - int64(n.numDocs)*8
+	if n.docTable < 0 {
+		return fmt.Errorf("name documents file does not match its config: %w", ErrCorruptIndex)
+	}
+	return nil
+}
+
+// Close releases the ngram FST and the memory mapped postings and documents
+func (n *NameIndex) Close() error {
+	return closeAll(n.idx, n.postings, n.docs)
+}
+
+func nameCreate(src *dataSource, indexDir string, cfg NameConfig) (*NameIndex, error) {
+	t := src.t
+	if _, err := ParseNgramType(string(cfg.NgramType)); err != nil {
+		return nil, err
+	}
+	if cfg.NgramSize < 1 {
+		return nil, NameError(fmt.Sprintf("invalid ngram size %d", cfg.NgramSize))
+	}
+
+	basics, err := src.open(IMDBBasics)
+	if err != nil {
+		return nil, err
+	}
+	defer basics.Close()
+
+	akas, err := src.open(IMDBAKAS)
+	if err != nil {
+		return nil, err
+	}
+	defer akas.Close()
+
+	docsFile, err := os.Create(path.Join(indexDir, NAMESDOCS))
+	if err != nil {
+		return nil, err
+	}
+	defer docsFile.Close()
+	docsWriter := bufio.NewWriter(docsFile)
+
+	// The postings of every ngram of every name do not fit in memory, so
+	// they are written as lines of an ngram, a document and a frequency and
+	// put in order by the external sort, which the postings are then read
+	// from one ngram at a time.
+	tuples, tuplesWriter := io.Pipe()
+	sorted, sortedWriter := io.Pipe()
+	go func() {
+		p := t.start(NAMESPOSTINGS, StageSort, 0)
+		err := writeSortedCSVRecords(tuples, sortedWriter, postingSort, src.sort, p)
+		if err == nil {
+			p.finish()
+		}
+		tuples.CloseWithError(err)
+		sortedWriter.CloseWithError(err)
+	}()
+	// stop the sort when returning early
+	defer sorted.Close()
+	defer tuplesWriter.CloseWithError(NameError("name index build stopped"))
+	tw := bufio.NewWriter(tuplesWriter)
+	if _, err = tw.WriteString("ngram\tdoc\tfreq\n"); err != nil {
+		return nil, err
+	}
+	var line []byte
+	var numPostings int64
+
+	var docOffsets []uint64
+	var docLens []uint32
+	var offset uint64
+	var totalLen uint64
+
+	addDoc := func(id, name string) error {
+		if len(docOffsets) == 1<<32-1 {
+			return NameError("too many names to index")
+		}
+		doc := uint32(len(docOffsets))
+		freqs, n := ngramCounts(cfg, name)
+		if n == 0 {
+			return nil
+		}
+		for gram, freq := range freqs {
+			line = append(line[:0], gram...)
+			line = append(line, '\t')
+			line = strconv.AppendUint(line, uint64(doc), 10)
+			line = append(line, '\t')
+			line = strconv.AppendUint(line, uint64(freq), 10)
+			line = append(line, '\n')
+			if _, err := tw.Write(line); err != nil {
+				return err
+			}
+		}
+		numPostings += int64(len(freqs))
+
+		buf, err := writeNameDoc(&nameDoc{id: id, name: name, len: n})
+		if err != nil {
+			return err
+		}
+		if _, err = docsWriter.Write(buf); err != nil {
+			return err
+		}
+		docOffsets = append(docOffsets, offset)
+		docLens = append(docLens, n)
+		offset += uint64(len(buf))
+		totalLen += uint64(n)
+		return nil
+	}
+
+	p := t.start(NAMES, StageParse, 0)
+	err = readSortedNames(basics, akas, func(id string, names []string) error {
+		for _, name := range names {
+			if err := addDoc(id, name); err != nil {
+				return err
+			}
+		}
+		return p.add(1)
+	})
+	if err != nil {
+		return nil, NameError(fmt.Sprintf("failed to read names: %v", err))
+	}
+	p.finish()
+
+	buf := make([]byte, 8)
+	for _, o := range docOffsets {
+		binary.BigEndian.PutUint64(buf, o)
+		if _, err = docsWriter.Write(buf); err != nil {
+			return nil, err
+		}
+	}
+	for _, l := range docLens {
+		binary.BigEndian.PutUint32(buf, l)
+		if _, err = docsWriter.Write(buf[:4]); err != nil {
+			return nil, err
+		}
+	}
+	binary.BigEndian.PutUint64(buf, uint64(len(docOffsets)))
+	if _, err = docsWriter.Write(buf); err != nil {
+		return nil, err
+	}
+	if err = docsWriter.Flush(); err != nil {
+		return nil, err
+	}
+	if err = docsFile.Close(); err != nil {
+		return nil, err
+	}
+
+	if err = tw.Flush(); err != nil {
+		return nil, err
+	}
+	if err = tuplesWriter.Close(); err != nil {
+		return nil, err
+	}
+	if err = writeNamePostings(indexDir, sorted, t.start(NAMES, StageInsert, numPostings)); err != nil {
+		return nil, err
+	}
+
+	meta := nameMeta{NameConfig: cfg, NumDocs: uint64(len(docOffsets))}
+	if len(docOffsets) > 0 {
+		meta.AvgLen = float64(totalLen) / float64(len(docOffsets))
+	}
+	metaFile, err := os.Create(path.Join(indexDir, NAMESCONFIG))
+	if err != nil {
+		return nil, err
+	}
+	err = json.NewEncoder(metaFile).Encode(&meta)
+	if cerr := metaFile.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return nameOpen(indexDir)
+}
+
+// postingSort orders the postings written by nameCreate by ngram, then by
+// document
+var postingSort = sortSpec{keys: []sortKey{{column: 0}, {column: 1, numeric: true}}, duplicates: true}
+
+// writeNamePostings writes the postings file and ngram FST from the sorted
+// postings lines in, counting every posting with p
+func writeNamePostings(indexDir string, in io.Reader, p *progress) error {
+	postingsFile, err := os.Create(path.Join(indexDir, NAMESPOSTINGS))
+	if err != nil {
+		return err
+	}
+	defer postingsFile.Close()
+	w := bufio.NewWriter(postingsFile)
+
+	builder, indexFile, err := fstSetBuilderFile(path.Join(indexDir, NAMES))
+	if err != nil {
+		return fmt.Errorf("failed to create fst set builder: %w", err)
+	}
+	defer indexFile.Close()
+
+	var offset uint64
+	var gram string
+	var ps []posting
+	buf := make([]byte, 4)
+	// flush writes the postings of the current ngram
+	flush := func() error {
+		if len(ps) == 0 {
+			return nil
+		}
+		if err := builder.Insert([]byte(gram), offset); err != nil {
+			return fmt.Errorf("failed to insert ngram %q: %w", gram, err)
+		}
+		binary.BigEndian.PutUint32(buf, uint32(len(ps)))
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+		for _, p := range ps {
+			binary.BigEndian.PutUint32(buf, p.doc)
+			if _, err := w.Write(buf); err != nil {
+				return err
+			}
+			binary.BigEndian.PutUint32(buf, p.freq)
+			if _, err := w.Write(buf); err != nil {
+				return err
+			}
+		}
+		offset += 4 + 8*uint64(len(ps))
+		ps = ps[:0]
+		return nil
+	}
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	// skip the header
+	scanner.Scan()
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 {
+			return NameError(fmt.Sprintf("invalid postings line %q", line))
+		}
+		doc, err := strconv.ParseUint(fields[1], 10, 32)
+		if err != nil {
+			return NameError(fmt.Sprintf("invalid postings line %q: %v", line, err))
+		}
+		freq, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			return NameError(fmt.Sprintf("invalid postings line %q: %v", line, err))
+		}
+
+		if fields[0] != gram {
+			if err = flush(); err != nil {
+				return err
+			}
+			gram = fields[0]
+		}
+		ps = append(ps, posting{uint32(doc), uint32(freq)})
+		if err = p.add(1); err != nil {
+			return err
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	if err = flush(); err != nil {
+		return err
+	}
+	p.finish()
+
+	if err = builder.Close(); err != nil {
+		return fmt.Errorf("failed to close ngram builder: %w", err)
+	}
+	if err = indexFile.Close(); err != nil {
+		return fmt.Errorf("failed to close ngram index file: %w", err)
+	}
+
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	return postingsFile.Close()
+}
+
+// Search returns the titles whose names best match the query
+func (n *NameIndex) Search(q *NameQuery) ([]*NameResult, error) {
+	size := q.Size
+	if size <= 0 {
+		size = 30
+	}
+
+	freqs, qlen := ngramCounts(n.meta.NameConfig, q.Name)
+	if qlen == 0 {
+		return nil, nil
+	}
+
+	scorer := q.Scorer
+	if scorer == nil {
+		scorer = DefaultNameScorer
+	}
+
+	matches := make(map[uint32][]NgramMatch)
+	for gram, qfreq := range freqs {
+		ps, err := n.postingsFor(gram)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range ps {
+			matches[p.doc] = append(matches[p.doc], NgramMatch{
+				QueryFreq: qfreq,
+				TermFreq:  p.freq,
+				DocCount:  uint32(len(ps)),
+			})
+		}
+	}
+
+	// documents are scored by number, and only the best are read
+	candidates := make([]nameCandidate, 0, len(matches))
+	for docID, ms := range matches {
+		docLen, err := n.docLen(docID)
+		if err != nil {
+			return nil, err
+		}
+		score := scorer.Score(ScoreStats{
+			NumDocs:  n.numDocs,
+			AvgLen:   n.meta.AvgLen,
+			QueryLen: qlen,
+			DocLen:   docLen,
+		}, ms)
+		candidates = append(candidates, nameCandidate{doc: docID, len: docLen, score: score})
+	}
+	// documents are numbered in the order of their title ids, so ties are
+	// broken by title id
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.score != b.score {
+			return a.score > b.score
+		}
+		if a.len != b.len {
+			return a.len < b.len
+		}
+		return a.doc < b.doc
+	})
+
+	// Only the best few candidates are re-ranked, so names sharing nothing
+	// but common ngrams with the query never get a say.
+	similar := q.Similarity != "" && q.Similarity != SimilarityNone
+	want := size
+	if similar {
+		want = size * rerankWindow
+	}
+	// every title keeps the best of its names
+	results := make([]*NameResult, 0, want)
+	seen := make(map[string]bool, want)
+	for _, c := range candidates {
+		if len(results) == want {
+			break
+		}
+		doc, err := n.doc(c.doc)
+		if err != nil {
+			return nil, err
+		}
+		if seen[doc.id] {
+			continue
+		}
+		seen[doc.id] = true
+		results = append(results, &NameResult{Id: doc.id, Name: doc.name, Score: c.score})
+	}
+	if !similar {
+		return results, nil
+	}
+
+	query := normalizeName(q.Name)
+	for _, r := range results {
+		r.Score = q.Similarity.Compare(query, normalizeName(r.Name))
+	}
+	// the stable sort keeps the scorer's order among equally similar names
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	if len(results) > size {
+		results = results[:size]
+	}
+	return results, nil
+}
+
+func (n *NameIndex) postingsFor(gram string) ([]posting, error) {
+	offset, valid, err := n.idx.Get([]byte(gram))
+	if err != nil {
+		return nil, err
+	}
+	if !valid {
+		return nil, nil
+	}
+
+	buf := make([]byte, 4)
+	if _, err = n.postings.ReadAt(buf, int64(offset)); err != nil {
+		return nil, fmt.Errorf("failed to read postings for %q: %w", gram, err)
+	}
+	count := binary.BigEndian.Uint32(buf)
+
+	buf = make([]byte, 8*int(count))
+	if _, err = n.postings.ReadAt(buf, int64(offset)+4); err != nil {
+		return nil, fmt.Errorf("failed to read postings for %q: %w", gram, err)
+	}
+	ps := make([]posting, count)
+	for i := range ps {
+		ps[i].doc = binary.BigEndian.Uint32(buf[i*8:])
+		ps[i].freq = binary.BigEndian.Uint32(buf[i*8+4:])
+	}
+	return ps, nil
+}
+
+func (n *NameIndex) doc(docID uint32) (*nameDoc, error) {
+	docLen, err := n.docLen(docID)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 8)
+	if _, err := n.docs.ReadAt(buf, n.docTable+int64(docID)*8); err != nil {
+		return nil, err
+	}
+	offset := int64(binary.BigEndian.Uint64(buf))
+
+	// the id and name are prefixed by their lengths
+	head := make([]byte, 2)
+	if _, err := n.docs.ReadAt(head, offset); err != nil {
+		return nil, err
+	}
+	idLen := int(binary.BigEndian.Uint16(head))
+
+	rest := make([]byte, idLen+2)
+	if _, err := n.docs.ReadAt(rest, offset+2); err != nil {
+		return nil, err
+	}
+	nameLen := int(binary.BigEndian.Uint16(rest[idLen:]))
+
+	name := make([]byte, nameLen)
+	if _, err := n.docs.ReadAt(name, offset+2+int64(idLen)+2); err != nil {
+		return nil, err
+	}
+
+	return &nameDoc{
+		id:   string(rest[:idLen]),
+		name: string(name),
+		len:  docLen,
+	}, nil
+}
+
+// docLen returns the number of ngrams of a document from the table of
+// lengths, without allocating
+func (n *NameIndex) docLen(docID uint32) (uint32, error) {
+	if uint64(docID) >= n.numDocs {
+		return 0, fmt.Errorf("name document %d out of range: %w", docID, ErrCorruptIndex)
+	}
+	i := int(n.lenTable) + 4*int(docID)
+	return uint32(n.docs.At(i))<<24 | uint32(n.docs.At(i+1))<<16 |
+		uint32(n.docs.At(i+2))<<8 | uint32(n.docs.At(i+3)), nil
+}
+
+// writeNameDoc encodes the id and name of a document, refusing ids and
+// names too long for the 16 bit lengths that prefix them
+func writeNameDoc(doc *nameDoc) ([]byte, error) {
+	if len(doc.id) > math.MaxUint16 || len(doc.name) > math.MaxUint16 {
+		return nil, NameError(fmt.Sprintf("name of %q is too long to index: %d bytes", doc.id, len(doc.name)))
+	}
+	buf := make([]byte, 2+len(doc.id)+2+len(doc.name))
+	binary.BigEndian.PutUint16(buf, uint16(len(doc.id)))
+	copy(buf[2:], doc.id)
+	i := 2 + len(doc.id)
+	binary.BigEndian.PutUint16(buf[i:], uint16(len(doc.name)))
+	copy(buf[i+2:], doc.name)
+	return buf, nil
+}
+
+// readSortedNames joins the basics and akas TSVs, both sorted by title id,
+// and calls fn once per title with its distinct names
+func readSortedNames(basics, akas io.Reader, fn func(id string, names []string) error) error {
+	titles, err := newNameSource(basics, 2, 3)
+	if err != nil {
+		return fmt.Errorf("failed to read basics: %w", err)
+	}
+	alternates, err := newNameSource(akas, 2)
+	if err != nil {
+		return fmt.Errorf("failed to read akas: %w", err)
+	}
+
+	for {
+		id := titles.peek()
+		if aid := alternates.peek(); id == "" || (aid != "" && aid < id) {
+			id = aid
+		}
+		if id == "" {
+			return nil
+		}
+
+		var names []string
+		if names, err = titles.take(id, names); err != nil {
+			return fmt.Errorf("failed to read basics: %w", err)
+		}
+		if names, err = alternates.take(id, names); err != nil {
+			return fmt.Errorf("failed to read akas: %w", err)
+		}
+
+		seen := make(map[string]bool, len(names))
+		distinct := names[:0]
+		for _, name := range names {
+			norm := normalizeName(name)
+			if norm == "" || seen[norm] {
+				continue
+			}
+			seen[norm] = true
+			distinct = append(distinct, name)
+		}
+
+		if err = fn(id, distinct); err != nil {
+			return err
+		}
+	}
+}
+
+// nameSource reads the names of one title at a time from a TSV sorted by
+// title id
+type nameSource struct {
+	r       *csv.Reader
+	columns []int
+	next    []string
+}
+
+func newNameSource(in io.Reader, columns ...int) (*nameSource, error) {
+	s := &nameSource{r: csvRBuilder(in), columns: columns}
+	// skip the header
+	if _, err := s.r.Read(); err != nil && err != io.EOF {
+		return nil, err
+	}
+	if err := s.advance(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *nameSource) advance() error {
+	rec, err := s.r.Read()
+	if err == io.EOF {
+		s.next = nil
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, c := range s.columns {
+		if c >= len(rec) {
+			return fmt.Errorf("expected at least %d columns, got %d: %v", c+1, len(rec), rec)
+		}
+	}
+	s.next = rec
+	return nil
+}
+
+func (s *nameSource) peek() string {
+	if s.next == nil {
+		return ""
+	}
+	return s.next[0]
+}
+
+func (s *nameSource) take(id string, names []string) ([]string, error) {
+	for s.next != nil && s.next[0] == id {
+		for _, c := range s.columns {
+			names = append(names, s.next[c])
+		}
+		if err := s.advance(); err != nil {
+			return nil, err
+		}
+	}
+	if s.next != nil && s.next[0] < id {
+		return nil, fmt.Errorf("records are not sorted: %q follows %q", s.next[0], id)
+	}
+	return names, nil
+}
+
+// normalizeName lowercases a name and reduces everything that is not a
+// letter or digit to single spaces
+func normalizeName(name string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range strings.ToLower(name) {
+		if r == '\'' {
+			continue
+		}
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			space = b.Len() > 0
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// ngrams splits a name into ngrams according to the config
+func ngrams(cfg NameConfig, name string) []string {
+	norm := []rune(normalizeName(name))
+	if len(norm) == 0 {
+		return nil
+	}
+
+	var grams []string
+	switch cfg.NgramType {
+	case NgramEdge:
+		for _, word := range strings.Fields(string(norm)) {
+			w := []rune(word)
+			start := 2
+			if cfg.NgramSize < start {
+				start = cfg.NgramSize
+			}
+			if len(w) < start {
+				start = len(w)
+			}
+			for i := start; i <= len(w) && i <= cfg.NgramSize; i++ {
+				grams = append(grams, string(w[:i]))
+			}
+		}
+	default:
+		if len(norm) <= cfg.NgramSize {
+			return []string{string(norm)}
+		}
+		for i := 0; i+cfg.NgramSize <= len(norm); i++ {
+			grams = append(grams, string(norm[i:i+cfg.NgramSize]))
+		}
+	}
+	return grams
+}
+
+// ngramCounts returns the frequency of every ngram in a name along with the
+// total number of ngrams
+func ngramCounts(cfg NameConfig, name string) (map[string]uint32, uint32) {
+	grams := ngrams(cfg, name)
+	counts := make(map[string]uint32, len(grams))
+	for _, g := range grams {
+		counts[g]++
+	}
+	return counts, uint32(len(grams))
+}
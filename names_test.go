@@ -0,0 +1,136 @@
+package imdb
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// index gets setup in episode_test.go:TestMain
+func TestNameSearchTypo(t *testing.T) {
+	idx, err := nameOpen(tmpDir)
+	if err != nil {
+		t.Fatalf("failed to open name index: %v", err)
+	}
+
+	results, err := idx.Search(&NameQuery{Name: "simpsns", Size: 5})
+	if err != nil {
+		t.Fatalf("failed to search names: %v", err)
+	}
+
+	if len(results) == 0 {
+		t.Fatalf("got no results")
+	}
+	if results[0].Id != "tt0096697" {
+		t.Fatalf("incorrect top result: got=%q want=%q", results[0].Id, "tt0096697")
+	}
+	if len(results) > 5 {
+		t.Fatalf("got too many results: %d", len(results))
+	}
+
+	seen := make(map[string]bool)
+	for _, r := range results {
+		if seen[r.Id] {
+			t.Fatalf("title %q returned more than once", r.Id)
+		}
+		seen[r.Id] = true
+	}
+}
+
+func TestNameSearchAka(t *testing.T) {
+	idx, err := nameOpen(tmpDir)
+	if err != nil {
+		t.Fatalf("failed to open name index: %v", err)
+	}
+
+	results, err := idx.Search(&NameQuery{Name: "simpsonowie"})
+	if err != nil {
+		t.Fatalf("failed to search names: %v", err)
+	}
+
+	if len(results) == 0 || results[0].Id != "tt0096697" || results[0].Name != "Simpsonowie" {
+		t.Fatalf("incorrect results: %+v", results)
+	}
+}
+
+func TestNgrams(t *testing.T) {
+	tests := []struct {
+		cfg  NameConfig
+		name string
+		want []string
+	}{
+		{NameConfig{NgramWindow, 3}, "Bart's Dog", []string{"bar", "art", "rts", "ts ", "s d", " do", "dog"}},
+		{NameConfig{NgramWindow, 3}, "Up", []string{"up"}},
+		{NameConfig{NgramEdge, 4}, "The Simpsons", []string{"th", "the", "si", "sim", "simp"}},
+		{NameConfig{NgramEdge, 3}, "I, Robot", []string{"i", "ro", "rob"}},
+		{NameConfig{NgramWindow, 3}, "!!", nil},
+	}
+
+	for _, tt := range tests {
+		got := ngrams(tt.cfg, tt.name)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("incorrect ngrams for %q: got=%q want=%q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseNgramType(t *testing.T) {
+	if _, err := ParseNgramType("trigram"); !errors.Is(err, ErrorUnknownNgramType) {
+		t.Fatalf("expected unknown ngram type error, got %v", err)
+	}
+}
+
+func TestWriteNameDocTooLong(t *testing.T) {
+	if _, err := writeNameDoc(&nameDoc{id: "tt0000001", name: "ok", len: 1}); err != nil {
+		t.Fatalf("failed to write document: %v", err)
+	}
+	long := strings.Repeat("a", 1<<16)
+	var nerr NameError
+	if _, err := writeNameDoc(&nameDoc{id: "tt0000001", name: long, len: 1}); !errors.As(err, &nerr) {
+		t.Fatalf("expected a name error, got %v", err)
+	}
+}
+
+func TestNameCreateSpilledPostings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imdb-index-names")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a tiny budget spills the postings to many sorted runs
+	src := newDataSource(nil, "testdata", SortOptions{MemoryBudget: 1 << 12})
+	idx, err := nameCreate(src, dir, DefaultNameConfig)
+	if err != nil {
+		t.Fatalf("failed to build name index: %v", err)
+	}
+	defer idx.Close()
+	want, err := nameOpen(tmpDir)
+	if err != nil {
+		t.Fatalf("failed to open name index: %v", err)
+	}
+	defer want.Close()
+
+	for _, name := range []string{"simpsns", "simpsonowie", "the"} {
+		got, err := idx.Search(&NameQuery{Name: name})
+		if err != nil {
+			t.Fatalf("failed to search names: %v", err)
+		}
+		exp, err := want.Search(&NameQuery{Name: name})
+		if err != nil {
+			t.Fatalf("failed to search names: %v", err)
+		}
+		// scores are sums over a map of ngrams, so only the order is compared
+		if len(got) != len(exp) {
+			t.Fatalf("got the wrong amount of results for %q: got=%d want=%d", name, len(got), len(exp))
+		}
+		for i := range got {
+			if got[i].Id != exp[i].Id || got[i].Name != exp[i].Name {
+				t.Fatalf("incorrect result %d for %q: got=%+v want=%+v", i, name, got[i], exp[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package imdb
+
+import (
+	"context"
+	"io"
+)
+
+// Stage is what a Progress counts
+type Stage string
+
+const (
+	// StageDownload counts the bytes of a compressed dataset downloaded.
+	StageDownload Stage = "download"
+	// StageSort counts the rows of a downloaded dataset sorted.
+	StageSort Stage = "sort"
+	// StageParse counts the rows of a dataset parsed while building an index.
+	StageParse Stage = "parse"
+	// StageInsert counts the keys inserted into an index.
+	StageInsert Stage = "insert"
+)
+
+// Progress reports how far one stage of a long running operation has come
+type Progress struct {
+	// The dataset or index file being processed, e.g. IMDBBasics or TITLES.
+	Name  string
+	Stage Stage
+	// The number of bytes, rows or keys processed so far.
+	Count int64
+	// The number expected in total, or 0 when it is not known up front.
+	Total int64
+	// Whether this is the last report of the stage.
+	Finished bool
+}
+
+// ProgressFunc receives progress reports. Datasets are processed concurrently,
+// so it may be called from several goroutines at once.
+type ProgressFunc func(Progress)
+
+// tracker hands out progress counters reporting to fn, which stop the
+// operation counted once ctx is done. A nil tracker counts nothing and never
+// stops.
+type tracker struct {
+	ctx context.Context
+	fn  ProgressFunc
+}
+
+func newTracker(ctx context.Context, fn ProgressFunc) *tracker {
+	return &tracker{ctx, fn}
+}
+
+// context returns the context of the tracker
+func (t *tracker) context() context.Context {
+	if t == nil {
+		return context.Background()
+	}
+	return t.ctx
+}
+
+// progressInterval is the number of rows or keys between reports, and how
+// often the context is checked
+const progressInterval = 10000
+
+// byteInterval is the number of bytes between reports
+const byteInterval = 1 << 20
+
+// start returns a counter for a stage of the named dataset or index
+func (t *tracker) start(name string, stage Stage, total int64) *progress {
+	if t == nil {
+		return nil
+	}
+	every := int64(progressInterval)
+	if stage == StageDownload {
+		every = byteInterval
+	}
+	p := &progress{t: t, every: every}
+	p.report = Progress{Name: name, Stage: stage, Total: total}
+	p.send()
+	return p
+}
+
+// progress counts one stage. A nil progress counts nothing.
+type progress struct {
+	t        *tracker
+	report   Progress
+	reported int64
+	every    int64
+}
+
+// add counts n more units. It returns the context's error once it is done.
+func (p *progress) add(n int64) error {
+	if p == nil {
+		return nil
+	}
+	p.report.Count += n
+	if p.report.Count-p.reported < p.every {
+		return nil
+	}
+	p.reported = p.report.Count
+	p.send()
+	return p.t.ctx.Err()
+}
+
+// err returns the error of the context once it is done
+func (p *progress) err() error {
+	if p == nil {
+		return nil
+	}
+	return p.t.ctx.Err()
+}
+
+// finish sends the last report of the stage
+func (p *progress) finish() {
+	if p == nil {
+		return
+	}
+	p.report.Finished = true
+	p.send()
+}
+
+func (p *progress) send() {
+	if p.t.fn != nil {
+		p.t.fn(p.report)
+	}
+}
+
+// progressWriter counts the bytes written through it
+type progressWriter struct {
+	w io.Writer
+	p *progress
+}
+
+func (w *progressWriter) Write(b []byte) (int, error) {
+	n, err := w.w.Write(b)
+	if perr := w.p.add(int64(n)); err == nil {
+		err = perr
+	}
+	return n, err
+}
+
+// contextReader fails reads once its context is done, so that loops over a
+// reader stop when an operation is cancelled
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(b []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(b)
+}
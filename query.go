@@ -0,0 +1,165 @@
+package imdb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jbpratt78/imdb-index/types"
+)
+
+type QueryError string
+
+func (e QueryError) Error() string { return string(e) }
+
+// kindDirectives are the value-less directives restricting the kinds of
+// titles returned. Every TitleKind is also accepted by its own name.
+var kindDirectives = map[string][]types.TitleKind{
+	"show":       {types.TVSeries, types.TVMiniSeries},
+	"series":     {types.TVSeries, types.TVMiniSeries},
+	"episode":    {types.TVEpisode},
+	"special":    {types.TVSpecial},
+	"game":       {types.VideoGame},
+	"miniseries": {types.TVMiniSeries},
+}
+
+// ParseQuery parses a one line query. Free text is the name to search for and
+// directives in braces refine the search:
+//
+//	{movie} {show} {episode} ...  restrict the kinds of titles returned
+//	{year:1989-1999}              start year within a range
+//	{votes:1000-}                 number of votes within a range
+//	{s:3} {e:7}                   season and episode number within a range
+//	{show:tt0096697}              episodes of the given TV show
+//	{size:20}                     maximum number of results
+//	{scorer:bm25}                 name scorer, see ScorerByName
+//	{sim:jaro-winkler}            similarity re-ranking, see ParseSimilarity
+//
+// Ranges are written as `a`, `a-`, `-b` or `a-b` and are inclusive.
+func ParseQuery(s string) (*types.Query, error) {
+	q := types.NewQuery("")
+	var name []string
+
+	for len(s) > 0 {
+		open := strings.IndexByte(s, '{')
+		if open < 0 {
+			name = append(name, strings.Fields(s)...)
+			break
+		}
+		name = append(name, strings.Fields(s[:open])...)
+
+		end := strings.IndexByte(s[open:], '}')
+		if end < 0 {
+			return nil, QueryError(fmt.Sprintf("unterminated directive %q", s[open:]))
+		}
+		if err := parseDirective(q, s[open+1:open+end]); err != nil {
+			return nil, err
+		}
+		s = s[open+end+1:]
+	}
+
+	q.Name = strings.Join(name, " ")
+	return q, nil
+}
+
+// ParseKinds returns the kinds of titles of a kind directive without its
+// braces, e.g. `show` or `tvMovie`, and whether it is one
+func ParseKinds(name string) ([]types.TitleKind, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if kinds, ok := kindDirectives[name]; ok {
+		return kinds, true
+	}
+	for _, kind := range types.TitleKinds {
+		if strings.ToLower(string(kind)) == name {
+			return []types.TitleKind{kind}, true
+		}
+	}
+	return nil, false
+}
+
+func parseDirective(q *types.Query, directive string) error {
+	key, value, hasValue := directive, "", false
+	if i := strings.IndexByte(directive, ':'); i >= 0 {
+		key, value, hasValue = directive[:i], strings.TrimSpace(directive[i+1:]), true
+	}
+	key = strings.ToLower(strings.TrimSpace(key))
+
+	if !hasValue {
+		kinds, ok := ParseKinds(key)
+		if !ok {
+			return fmt.Errorf("%w: {%s}", ErrorUnknownDirective, directive)
+		}
+		q.Kinds = append(q.Kinds, kinds...)
+		return nil
+	}
+
+	var err error
+	switch key {
+	case "year":
+		q.Year, err = parseRange(value)
+	case "votes":
+		q.Votes, err = parseRange(value)
+	case "s", "season":
+		q.Season, err = parseRange(value)
+	case "e", "episode":
+		q.Episode, err = parseRange(value)
+	case "show", "tvshow":
+		if value == "" {
+			err = QueryError("missing show id")
+		}
+		q.TvShowID = value
+	case "size":
+		var size uint64
+		size, err = strconv.ParseUint(value, 10, 32)
+		q.Size = uint(size)
+	case "scorer":
+		_, err = ScorerByName(value)
+		q.NameScorer = value
+	case "sim", "similarity":
+		_, err = ParseSimilarity(value)
+		q.Similarity = value
+	default:
+		return fmt.Errorf("%w: {%s}", ErrorUnknownDirective, directive)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid directive {%s}: %w", directive, err)
+	}
+	return nil
+}
+
+// parseRange parses an inclusive range written as `a`, `a-`, `-b` or `a-b`
+func parseRange(s string) (types.Range, error) {
+	var r types.Range
+	parse := func(n string) (*uint32, error) {
+		n = strings.TrimSpace(n)
+		if n == "" {
+			return nil, nil
+		}
+		v, err := strconv.ParseUint(n, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		u := uint32(v)
+		return &u, nil
+	}
+
+	start, end := s, s
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		start, end = s[:i], s[i+1:]
+	}
+
+	var err error
+	if r.Start, err = parse(start); err != nil {
+		return r, err
+	}
+	if r.End, err = parse(end); err != nil {
+		return r, err
+	}
+	if r.IsUnbounded() {
+		return r, QueryError(fmt.Sprintf("empty range %q", s))
+	}
+	if r.Start != nil && r.End != nil && *r.Start > *r.End {
+		return r, QueryError(fmt.Sprintf("range %q ends before it starts", s))
+	}
+	return r, nil
+}
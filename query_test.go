@@ -0,0 +1,93 @@
+package imdb
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/jbpratt78/imdb-index/types"
+)
+
+func TestParseQuery(t *testing.T) {
+	q, err := ParseQuery("the simpsons {show} {year:1989-} {votes:1000-} {s:3} {e:7} {size:20} {scorer:bm25}")
+	if err != nil {
+		t.Fatalf("failed to parse query: %v", err)
+	}
+
+	if q.Name != "the simpsons" {
+		t.Fatalf("incorrect name: %q", q.Name)
+	}
+	if !reflect.DeepEqual(q.Kinds, []types.TitleKind{types.TVSeries, types.TVMiniSeries}) {
+		t.Fatalf("incorrect kinds: %v", q.Kinds)
+	}
+	if q.Year.Start == nil || *q.Year.Start != 1989 || q.Year.End != nil {
+		t.Fatalf("incorrect year: %+v", q.Year)
+	}
+	if !q.Votes.Contains(1000) || q.Votes.Contains(999) {
+		t.Fatalf("incorrect votes: %+v", q.Votes)
+	}
+	if !q.Season.Contains(3) || q.Season.Contains(4) || q.Season.Contains(2) {
+		t.Fatalf("incorrect season: %+v", q.Season)
+	}
+	if !q.Episode.Contains(7) || q.Episode.Contains(8) {
+		t.Fatalf("incorrect episode: %+v", q.Episode)
+	}
+	if q.Size != 20 || q.NameScorer != "bm25" {
+		t.Fatalf("incorrect size or scorer: %d %q", q.Size, q.NameScorer)
+	}
+}
+
+func TestParseQueryDefaults(t *testing.T) {
+	q, err := ParseQuery("  bart   {movie}the general {tvSpecial} {show:tt0096697} {sim:jaro}")
+	if err != nil {
+		t.Fatalf("failed to parse query: %v", err)
+	}
+
+	if q.Name != "bart the general" {
+		t.Fatalf("incorrect name: %q", q.Name)
+	}
+	if !reflect.DeepEqual(q.Kinds, []types.TitleKind{types.Movie, types.TVSpecial}) {
+		t.Fatalf("incorrect kinds: %v", q.Kinds)
+	}
+	if q.TvShowID != "tt0096697" || q.Similarity != "jaro" || q.Size != 30 {
+		t.Fatalf("incorrect query: %+v", q)
+	}
+	if !q.Year.IsUnbounded() {
+		t.Fatalf("year should be unbounded: %+v", q.Year)
+	}
+}
+
+func TestParseQueryRanges(t *testing.T) {
+	r, err := parseRange("-1999")
+	if err != nil || r.Start != nil || !r.Contains(1999) || r.Contains(2000) {
+		t.Fatalf("incorrect range: %+v %v", r, err)
+	}
+	r, err = parseRange("1990-1999")
+	if err != nil || r.Contains(1989) || !r.Contains(1995) || r.Contains(2000) {
+		t.Fatalf("incorrect range: %+v %v", r, err)
+	}
+
+	for _, bad := range []string{"-", "x", "1999-1990", "1-2-3"} {
+		if _, err := parseRange(bad); err == nil {
+			t.Fatalf("expected error for range %q", bad)
+		}
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	if _, err := ParseQuery("simpsons {rating:9}"); !errors.Is(err, ErrorUnknownDirective) {
+		t.Fatalf("expected unknown directive error, got %v", err)
+	}
+	if _, err := ParseQuery("simpsons {cartoon}"); !errors.Is(err, ErrorUnknownDirective) {
+		t.Fatalf("expected unknown directive error, got %v", err)
+	}
+	if _, err := ParseQuery("simpsons {scorer:pagerank}"); !errors.Is(err, ErrorUnknownScorer) {
+		t.Fatalf("expected unknown scorer error, got %v", err)
+	}
+	if _, err := ParseQuery("simpsons {sim:cosine}"); !errors.Is(err, ErrorUnknownSimilarity) {
+		t.Fatalf("expected unknown similarity error, got %v", err)
+	}
+	if _, err := ParseQuery("simpsons {year:1989"); err == nil {
+		t.Fatalf("expected error for unterminated directive")
+	}
+}
@@ -1,4 +1,4 @@
-package main
+package imdb
 
 import (
 	"bytes"
@@ -7,36 +7,53 @@ import (
 	"fmt"
 	"io"
 	"math"
-	"os"
 	"path"
+	"sort"
 	"strconv"
 
 	"github.com/couchbase/vellum"
-	"github.com/jbpratt78/imdb-index/internal/types"
+	"github.com/jbpratt78/imdb-index/types"
 )
 
-const RATINGS = "ratings.fst"
+const (
+	RATINGS = "ratings.fst"
+	// RATINGSRANK holds the ratings ordered from the highest rating and most
+	// votes down, for range and top-N queries.
+	RATINGSRANK = "ratings.rank.fst"
+)
 
 type RatingsError string
 
 func (e RatingsError) Error() string { return string(e) }
 
 type RatingsIndex struct {
-	idx *vellum.FST
+	idx  *vellum.FST
+	rank *vellum.FST
 }
 
-func RatingsOpen(indexDir string) (*RatingsIndex, error) {
+func ratingsOpen(indexDir string) (*RatingsIndex, error) {
 	idx, err := fstSetFile(path.Join(indexDir, RATINGS))
 	if err != nil {
 		return nil, err
 	}
-	return &RatingsIndex{idx}, nil
+	rank, err := fstSetFile(path.Join(indexDir, RATINGSRANK))
+	if err != nil {
+		idx.Close()
+		return nil, err
+	}
+	return &RatingsIndex{idx, rank}, nil
 }
 
-func RatingsCreate(dataDir, indexDir string) (*RatingsIndex, error) {
+// Close releases both FSTs
+func (i *RatingsIndex) Close() error {
+	return closeAll(i.idx, i.rank)
+}
+
+func ratingsCreate(src *dataSource, indexDir string) (*RatingsIndex, error) {
+	t := src.t
 
 	fstRatingsFile := path.Join(indexDir, RATINGS)
-	tsv, err := os.Open(path.Join(dataDir, IMDBRatings))
+	tsv, err := src.open(IMDBRatings)
 	if err != nil {
 		return nil, err
 	}
@@ -47,11 +64,12 @@ func RatingsCreate(dataDir, indexDir string) (*RatingsIndex, error) {
 		return nil, err
 	}
 
-	ratings, err := readSortedRatings(tsv)
+	ratings, err := readSortedRatings(tsv, t.start(IMDBRatings, StageParse, 0))
 	if err != nil {
 		return nil, RatingsError(fmt.Sprintf("failed to read ratings tsv: %v", err))
 	}
 
+	p := t.start(RATINGS, StageInsert, int64(len(ratings)))
 	for _, r := range ratings {
 		buffer, err := writeRating(r)
 		if err != nil {
@@ -61,14 +79,53 @@ func RatingsCreate(dataDir, indexDir string) (*RatingsIndex, error) {
 		if err = ratingsBuilder.Insert(buffer, r.Offset); err != nil {
 			return nil, fmt.Errorf("failed to insert rating into ratings builder: %w", err)
 		}
+		if err = p.add(1); err != nil {
+			return nil, err
+		}
 	}
+	p.finish()
 
 	if err = ratingsBuilder.Close(); err != nil {
 		return nil, fmt.Errorf("failed to create fst set builder: %w", err)
 	}
-	ratingsIndexFile.Close()
+	if err = ratingsIndexFile.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close ratings index file: %w", err)
+	}
 
-	return RatingsOpen(indexDir)
+	rankBuilder, rankIndexFile, err := fstSetBuilderFile(path.Join(indexDir, RATINGSRANK))
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(ratings, func(i, j int) bool {
+		if ratings[i].Rating != ratings[j].Rating {
+			return ratings[i].Rating > ratings[j].Rating
+		}
+		if ratings[i].Votes != ratings[j].Votes {
+			return ratings[i].Votes > ratings[j].Votes
+		}
+		return ratings[i].Id < ratings[j].Id
+	})
+
+	p = t.start(RATINGSRANK, StageInsert, int64(len(ratings)))
+	for _, r := range ratings {
+		if err = rankBuilder.Insert(writeRatingRank(r), r.Offset); err != nil {
+			return nil, fmt.Errorf("failed to insert rating into rank builder: %w", err)
+		}
+		if err = p.add(1); err != nil {
+			return nil, err
+		}
+	}
+	p.finish()
+
+	if err = rankBuilder.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close rank builder: %w", err)
+	}
+	if err = rankIndexFile.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close rank index file: %w", err)
+	}
+
+	return ratingsOpen(indexDir)
 }
 
 func ratingsRange(
@@ -78,6 +135,9 @@ func ratingsRange(
 ) ([]*types.Rating, error) {
 	var ratings []*types.Rating
 	itr, err := fst.Iterator(lower, upper)
+	if errors.Is(err, vellum.ErrIteratorDone) {
+		return nil, nil
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -93,19 +153,106 @@ func ratingsRange(
 	if errors.Is(err, vellum.ErrIteratorDone) {
 		return ratings, nil
 	}
-	return nil, RatingsError("iterator did not finish")
+	return nil, fmt.Errorf("failed to iterate ratings: %v: %w", err, ErrCorruptIndex)
 }
 
+// Rating returns the rating of the title with the given id
 func (i *RatingsIndex) Rating(id []uint8) (*types.Rating, error) {
-	upper := append(id, 0xFF)
-	ratings, err := ratingsRange(id, upper, i.idx, readRating)
+	rating, ok, err := i.Lookup(id)
 	if err != nil {
 		return nil, err
 	}
-	return ratings[0], nil
+	if !ok {
+		return nil, fmt.Errorf("rating of %q: %w", id, ErrNotFound)
+	}
+	return rating, nil
+}
+
+// RatingQuery selects ratings by their rating and number of votes
+type RatingQuery struct {
+	// MinRating and MaxRating bound the rating, inclusive. A MaxRating of 0
+	// is unbounded.
+	MinRating float32
+	MaxRating float32
+	// MinVotes is the least number of votes of a rating.
+	MinVotes uint32
+	// Kinds restricts the ratings to titles of these kinds, which needs the
+	// title index, see Index.TopRated. Empty for every kind.
+	Kinds []types.TitleKind
+	// Limit is the most ratings returned, 0 for all of them.
+	Limit int
 }
 
-func readSortedRatings(in *os.File) ([]*types.Rating, error) {
+// Top returns the ratings selected by q, from the highest rating down and
+// by most votes among equal ratings. Restricting the kinds of titles is left
+// to Index.TopRated.
+func (i *RatingsIndex) Top(q RatingQuery) ([]*types.Rating, error) {
+	if len(q.Kinds) > 0 {
+		return nil, RatingsError("restricting the kinds of titles needs the title index")
+	}
+	var ratings []*types.Rating
+	err := i.rankRange(q, func(r *types.Rating) (bool, error) {
+		ratings = append(ratings, r)
+		return true, nil
+	})
+	return ratings, err
+}
+
+// rankRange calls keep with the ratings selected by q in the order of Top,
+// until keep accepted q.Limit of them
+func (i *RatingsIndex) rankRange(q RatingQuery, keep func(*types.Rating) (bool, error)) error {
+	if math.IsNaN(float64(q.MinRating)) || math.IsNaN(float64(q.MaxRating)) || q.MinRating < 0 || q.MaxRating < 0 {
+		return RatingsError(fmt.Sprintf("invalid rating range %v-%v", q.MinRating, q.MaxRating))
+	}
+	if q.MaxRating != 0 && q.MaxRating < q.MinRating {
+		return RatingsError(fmt.Sprintf("rating range %v-%v ends before it starts", q.MinRating, q.MaxRating))
+	}
+
+	// ranks are inverted, so the highest rating bounds the range from below
+	var lower []byte
+	if q.MaxRating != 0 {
+		lower = rankPrefix(q.MaxRating)
+	}
+	_, upper := prefixBounds(rankPrefix(q.MinRating))
+
+	itr, err := i.rank.Iterator(lower, upper)
+	kept := 0
+	for err == nil {
+		key, _ := itr.Current()
+		r := readRatingRank(key)
+		if r.Votes >= q.MinVotes {
+			ok, kerr := keep(r)
+			if kerr != nil {
+				return kerr
+			}
+			if ok {
+				if kept++; kept == q.Limit {
+					return nil
+				}
+			}
+		}
+		err = itr.Next()
+	}
+	if errors.Is(err, vellum.ErrIteratorDone) {
+		return nil
+	}
+	return fmt.Errorf("failed to iterate ratings: %v: %w", err, ErrCorruptIndex)
+}
+
+// Lookup returns the rating of exactly the given id and whether it exists
+func (i *RatingsIndex) Lookup(id []uint8) (*types.Rating, bool, error) {
+	lower, upper := exactBounds(id)
+	ratings, err := ratingsRange(lower, upper, i.idx, readRating)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(ratings) == 0 {
+		return nil, false, nil
+	}
+	return ratings[0], true, nil
+}
+
+func readSortedRatings(in io.Reader, p *progress) ([]*types.Rating, error) {
 	ratings := []*types.Rating{}
 	var count uint64 = 0
 	var buf bytes.Buffer
@@ -149,7 +296,11 @@ func readSortedRatings(in *os.File) ([]*types.Rating, error) {
 		ratings = append(ratings, &types.Rating{Offset: offset, Id: rec[0], Rating: float32(rating), Votes: uint32(votes)})
 
 		count++
+		if err = p.add(1); err != nil {
+			return nil, err
+		}
 	}
+	p.finish()
 	return ratings, nil
 }
 
@@ -190,3 +341,27 @@ func readRating(key []byte) *types.Rating {
 	votes := binary.BigEndian.Uint32(key[i+4:])
 	return &types.Rating{Id: string(id), Rating: rating, Votes: votes}
 }
+
+// writeRatingRank returns the key of a rating in RATINGSRANK: the rating and
+// votes inverted, so that the highest come first, then the id. Ratings are
+// never negative, so the bits of their floats order like the ratings.
+func writeRatingRank(rt *types.Rating) []uint8 {
+	buffer := rankPrefix(rt.Rating)
+	x := make([]byte, 4)
+	binary.BigEndian.PutUint32(x, ^rt.Votes)
+	buffer = append(buffer, x...)
+	return append(buffer, []uint8(rt.Id)...)
+}
+
+// rankPrefix returns the start of the RATINGSRANK keys of a rating
+func rankPrefix(rating float32) []uint8 {
+	x := make([]byte, 4)
+	binary.BigEndian.PutUint32(x, ^math.Float32bits(rating))
+	return x
+}
+
+func readRatingRank(key []byte) *types.Rating {
+	rating := math.Float32frombits(^binary.BigEndian.Uint32(key))
+	votes := ^binary.BigEndian.Uint32(key[4:])
+	return &types.Rating{Id: string(key[8:]), Rating: rating, Votes: votes}
+}
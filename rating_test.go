@@ -1,10 +1,16 @@
-package main
+package imdb
 
-import "testing"
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/jbpratt78/imdb-index/types"
+)
 
 // index gets setup in episode_test.go:TestMain
 func TestRatingBasic(t *testing.T) {
-	idx, err := RatingsOpen(tmpDir)
+	idx, err := ratingsOpen(tmpDir)
 	if err != nil {
 		t.Fatalf("failed to open ratings index: %v", err)
 	}
@@ -22,3 +28,73 @@ func TestRatingBasic(t *testing.T) {
 		t.Fatalf("incorrect votes: %d", rating.Votes)
 	}
 }
+
+func TestRatingMissing(t *testing.T) {
+	idx, err := ratingsOpen(tmpDir)
+	if err != nil {
+		t.Fatalf("failed to open ratings index: %v", err)
+	}
+	defer idx.Close()
+
+	// an id prefix of a rated title is not rated itself
+	if _, err := idx.Rating([]byte("tt000000")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected not found error, got: %v", err)
+	}
+	if _, ok, err := idx.Lookup([]byte("tt000000")); ok || err != nil {
+		t.Fatalf("expected a missing rating to be no error: %v %v", ok, err)
+	}
+}
+
+func TestRatingTop(t *testing.T) {
+	idx, err := ratingsOpen(tmpDir)
+	if err != nil {
+		t.Fatalf("failed to open ratings index: %v", err)
+	}
+	defer idx.Close()
+
+	tests := []struct {
+		q    RatingQuery
+		want []string
+	}{
+		{RatingQuery{MinRating: 6, MaxRating: 7}, []string{"tt0000010", "tt0000019", "tt0000003", "tt0000002", "tt0000004", "tt0000005", "tt0000015"}},
+		{RatingQuery{MinRating: 7, MinVotes: 3000, Limit: 2}, []string{"tt0096697", "tt0000012"}},
+		{RatingQuery{MinRating: 7.3, MaxRating: 7.3}, []string{"tt0701063"}},
+		{RatingQuery{MinRating: 9}, nil},
+	}
+	for _, tt := range tests {
+		ratings, err := idx.Top(tt.q)
+		if err != nil {
+			t.Fatalf("failed to query %+v: %v", tt.q, err)
+		}
+		var got []string
+		for _, r := range ratings {
+			got = append(got, r.Id)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("incorrect ratings for %+v: got=%v want=%v", tt.q, got, tt.want)
+		}
+	}
+
+	if _, err := idx.Top(RatingQuery{MinRating: 7, MaxRating: 6}); err == nil {
+		t.Fatalf("expected error for an empty range")
+	}
+	if _, err := idx.Top(RatingQuery{Kinds: []types.TitleKind{types.Movie}}); err == nil {
+		t.Fatalf("expected error for kinds without the title index")
+	}
+}
+
+func TestIndexTopRated(t *testing.T) {
+	idx, err := Open(tmpDir)
+	if err != nil {
+		t.Fatalf("failed to open index: %v", err)
+	}
+	defer idx.Close()
+
+	rated, err := idx.TopRated(RatingQuery{Kinds: []types.TitleKind{types.TVEpisode}, Limit: 1})
+	if err != nil {
+		t.Fatalf("failed to query top rated episodes: %v", err)
+	}
+	if len(rated) != 1 || rated[0].Title.Id != "tt0701062" || rated[0].Rating.Votes != 2852 {
+		t.Fatalf("incorrect top rated episodes: %+v", rated)
+	}
+}
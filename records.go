@@ -0,0 +1,256 @@
+package imdb
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"golang.org/x/exp/mmap"
+)
+
+// recordBlockSize is the size records are gathered to before a block is
+// compressed. A lookup decompresses the one or two blocks its records are in.
+const recordBlockSize = 1 << 14
+
+// A record store holds the parsed columns of the rows of a dataset, so that
+// the title and akas indices read their records back from the index
+// directory alone. Records are gathered into blocks, each written as the
+// big-endian length of its deflated contents followed by them, and the
+// store ends with the big-endian number of records. A record is the number
+// of its columns followed by every column, prefixed by its length, all as
+// uvarints.
+//
+// The address of a record is the offset of its block in the store shifted
+// left 16 bits, ORed with its offset in the decompressed block. Addresses
+// fit in 48 bits and increase with every record added.
+
+// recordWriter adds records to a record store
+type recordWriter struct {
+	w *bufio.Writer
+	c io.Closer
+
+	zw         *flate.Writer
+	block      bytes.Buffer
+	compressed bytes.Buffer
+	// offset of the current block in the store
+	offset  uint64
+	records uint64
+	closed  bool
+}
+
+// createRecordStore creates a record store at path
+func createRecordStore(path string) (*recordWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w, err := newRecordWriter(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	w.c = f
+	return w, nil
+}
+
+func newRecordWriter(w io.Writer) (*recordWriter, error) {
+	zw, err := flate.NewWriter(nil, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	return &recordWriter{w: bufio.NewWriter(w), zw: zw}, nil
+}
+
+// add appends a record to the store and returns its address
+func (w *recordWriter) add(rec []string) (uint64, error) {
+	if w.block.Len() >= recordBlockSize {
+		if err := w.flush(); err != nil {
+			return 0, err
+		}
+	}
+	if w.offset >= 1<<32 {
+		return 0, fmt.Errorf("record store is past its maximum size of %d bytes", uint64(1<<32))
+	}
+	addr := w.offset<<16 | uint64(w.block.Len())
+
+	var n [binary.MaxVarintLen64]byte
+	w.block.Write(n[:binary.PutUvarint(n[:], uint64(len(rec)))])
+	for _, col := range rec {
+		w.block.Write(n[:binary.PutUvarint(n[:], uint64(len(col)))])
+		w.block.WriteString(col)
+	}
+	w.records++
+	return addr, nil
+}
+
+// flush compresses and writes the current block
+func (w *recordWriter) flush() error {
+	if w.block.Len() == 0 {
+		return nil
+	}
+	w.compressed.Reset()
+	w.zw.Reset(&w.compressed)
+	if _, err := w.zw.Write(w.block.Bytes()); err != nil {
+		return err
+	}
+	if err := w.zw.Close(); err != nil {
+		return err
+	}
+
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(w.compressed.Len()))
+	if _, err := w.w.Write(size[:]); err != nil {
+		return err
+	}
+	if _, err := w.w.Write(w.compressed.Bytes()); err != nil {
+		return err
+	}
+	w.offset += uint64(len(size) + w.compressed.Len())
+	w.block.Reset()
+	return nil
+}
+
+// Close writes the last block and the number of records and closes the
+// store. Closing it again does nothing.
+func (w *recordWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	err := w.flush()
+	if err == nil {
+		var n [8]byte
+		binary.BigEndian.PutUint64(n[:], w.records)
+		_, err = w.w.Write(n[:])
+	}
+	if err == nil {
+		err = w.w.Flush()
+	}
+	if w.c != nil {
+		if cerr := w.c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// recordStore reads the records of a store written by a recordWriter
+type recordStore struct {
+	r io.ReaderAt
+	c io.Closer
+	// end of the blocks, where the number of records starts
+	end     int64
+	records int64
+}
+
+// openRecordStore memory maps the record store at path
+func openRecordStore(path string) (*recordStore, error) {
+	m, err := mmap.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := newRecordStore(m, int64(m.Len()))
+	if err != nil {
+		m.Close()
+		return nil, fmt.Errorf("%s: %v: %w", path, err, ErrCorruptIndex)
+	}
+	s.c = m
+	return s, nil
+}
+
+func newRecordStore(r io.ReaderAt, size int64) (*recordStore, error) {
+	if size < 8 {
+		return nil, fmt.Errorf("record store is %d bytes, too short to hold its number of records", size)
+	}
+	var n [8]byte
+	if _, err := r.ReadAt(n[:], size-8); err != nil {
+		return nil, err
+	}
+	return &recordStore{r: r, end: size - 8, records: int64(binary.BigEndian.Uint64(n[:]))}, nil
+}
+
+// Close releases the memory mapped store
+func (s *recordStore) Close() error {
+	if s.c == nil {
+		return nil
+	}
+	return s.c.Close()
+}
+
+// read returns n records starting with the one at addr
+func (s *recordStore) read(addr uint64, n int) ([][]string, error) {
+	block, pos := int64(addr>>16), int(addr&0xffff)
+	data, next, err := s.block(block)
+	if err != nil {
+		return nil, err
+	}
+
+	recs := make([][]string, 0, n)
+	for len(recs) < n {
+		if pos > len(data) {
+			return nil, fmt.Errorf("record address %x is past the end of its block", addr)
+		}
+		if pos == len(data) {
+			// the records go on in the next block
+			if data, next, err = s.block(next); err != nil {
+				return nil, err
+			}
+			pos = 0
+		}
+		rec, size, err := decodeRecord(data[pos:])
+		if err != nil {
+			return nil, fmt.Errorf("record %d at address %x: %v", len(recs)+1, addr, err)
+		}
+		recs = append(recs, rec)
+		pos += size
+	}
+	return recs, nil
+}
+
+// block returns the decompressed block at offset and the offset of the next
+func (s *recordStore) block(offset int64) ([]byte, int64, error) {
+	if offset < 0 || offset+4 > s.end {
+		return nil, 0, fmt.Errorf("block offset %d is past the end of the record store", offset)
+	}
+	var n [4]byte
+	if _, err := s.r.ReadAt(n[:], offset); err != nil {
+		return nil, 0, err
+	}
+	size := int64(binary.BigEndian.Uint32(n[:]))
+	if offset+4+size > s.end {
+		return nil, 0, fmt.Errorf("block at offset %d of %d bytes is past the end of the record store", offset, size)
+	}
+
+	zr := flate.NewReader(io.NewSectionReader(s.r, offset+4, size))
+	defer zr.Close()
+	data, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to decompress block at offset %d: %v", offset, err)
+	}
+	return data, offset + 4 + size, nil
+}
+
+// decodeRecord decodes the record at the start of b and returns its size
+func decodeRecord(b []byte) ([]string, int, error) {
+	cols, pos := binary.Uvarint(b)
+	if pos <= 0 || cols > uint64(len(b)) {
+		return nil, 0, fmt.Errorf("invalid number of columns")
+	}
+	rec := make([]string, cols)
+	for i := range rec {
+		size, n := binary.Uvarint(b[pos:])
+		if n <= 0 || size > uint64(len(b)-pos-n) {
+			return nil, 0, fmt.Errorf("invalid length of column %d", i+1)
+		}
+		pos += n
+		rec[i] = string(b[pos : pos+int(size)])
+		pos += int(size)
+	}
+	return rec, pos, nil
+}
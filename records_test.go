@@ -0,0 +1,97 @@
+package imdb
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestRecordStore(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := newRecordWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// enough records for several blocks
+	var recs [][]string
+	var addrs []uint64
+	for i := 0; i < 5000; i++ {
+		rec := []string{fmt.Sprintf("tt%07d", i), "movie", fmt.Sprintf("Title %d", i), `\N`, ""}
+		addr, err := w.add(rec)
+		if err != nil {
+			t.Fatalf("failed to add record: %v", err)
+		}
+		if len(addrs) > 0 && addr <= addrs[len(addrs)-1] {
+			t.Fatalf("address %x is not after %x", addr, addrs[len(addrs)-1])
+		}
+		recs = append(recs, rec)
+		addrs = append(addrs, addr)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("failed to close store: %v", err)
+	}
+	if addrs[len(addrs)-1]>>16 == 0 {
+		t.Fatalf("expected the records to span several blocks")
+	}
+
+	s, err := newRecordStore(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	if s.records != int64(len(recs)) {
+		t.Fatalf("got the wrong amount of records: got=%d want=%d", s.records, len(recs))
+	}
+	for _, i := range []int{0, 1, 2500, len(recs) - 1} {
+		got, err := s.read(addrs[i], 1)
+		if err != nil {
+			t.Fatalf("failed to read record %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(got[0], recs[i]) {
+			t.Fatalf("incorrect record %d: got=%v want=%v", i, got[0], recs[i])
+		}
+	}
+
+	// reads go on across blocks
+	all, err := s.read(addrs[0], len(recs))
+	if err != nil {
+		t.Fatalf("failed to read every record: %v", err)
+	}
+	if !reflect.DeepEqual(all, recs) {
+		t.Fatalf("incorrect records")
+	}
+	if _, err = s.read(addrs[len(addrs)-1], 2); err == nil {
+		t.Fatalf("expected an error reading past the last record")
+	}
+}
+
+func TestRecordStoreCorrupt(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := newRecordWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := w.add([]string{"tt0000001", "movie"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+	// claim the block is longer than the store
+	data[0] = 0xff
+	s, err := newRecordStore(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	if _, err = s.read(addr, 1); err == nil {
+		t.Fatalf("expected an error reading a truncated block")
+	}
+
+	if _, err = newRecordStore(bytes.NewReader(data[:4]), 4); err == nil {
+		t.Fatalf("expected an error opening a truncated store")
+	}
+}
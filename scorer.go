@@ -0,0 +1,114 @@
+package imdb
+
+import (
+	"fmt"
+	"math"
+)
+
+// NameScorer ranks the candidate documents retrieved from a NameIndex
+type NameScorer interface {
+	// Score returns the relevance of a document given the query ngrams it
+	// contains. Higher is better.
+	Score(stats ScoreStats, matches []NgramMatch) float64
+}
+
+// ScoreStats describes the corpus, the query and the document being scored
+type ScoreStats struct {
+	// The number of documents in the name index.
+	NumDocs uint64
+	// The average number of ngrams in a document.
+	AvgLen float64
+	// The number of ngrams in the query.
+	QueryLen uint32
+	// The number of ngrams in the document.
+	DocLen uint32
+}
+
+// NgramMatch is a single query ngram found in a document
+type NgramMatch struct {
+	// The number of times the ngram occurs in the query.
+	QueryFreq uint32
+	// The number of times the ngram occurs in the document.
+	TermFreq uint32
+	// The number of documents in the index containing the ngram.
+	DocCount uint32
+}
+
+// OkapiBM25 scores documents with the Okapi BM25 ranking function
+type OkapiBM25 struct {
+	K1 float64
+	B  float64
+}
+
+func (s OkapiBM25) Score(stats ScoreStats, matches []NgramMatch) float64 {
+	norm := 1.0
+	if stats.AvgLen > 0 {
+		norm = 1 - s.B + s.B*float64(stats.DocLen)/stats.AvgLen
+	}
+
+	var score float64
+	for _, m := range matches {
+		df := float64(m.DocCount)
+		idf := math.Log(1 + (float64(stats.NumDocs)-df+0.5)/(df+0.5))
+		tf := float64(m.TermFreq)
+		score += float64(m.QueryFreq) * idf * tf * (s.K1 + 1) / (tf + s.K1*norm)
+	}
+	return score
+}
+
+// TFIDF scores documents by the sum of term frequency times inverse document
+// frequency of every matching ngram, with term frequencies normalized by the
+// document length
+type TFIDF struct{}
+
+func (TFIDF) Score(stats ScoreStats, matches []NgramMatch) float64 {
+	if stats.DocLen == 0 {
+		return 0
+	}
+
+	var score float64
+	for _, m := range matches {
+		idf := 1 + math.Log(float64(stats.NumDocs)/float64(m.DocCount))
+		tf := float64(m.TermFreq) / float64(stats.DocLen)
+		score += float64(m.QueryFreq) * tf * idf
+	}
+	return score
+}
+
+// Jaccard scores documents by the Jaccard index of the query and document
+// ngram multisets. It ignores how common an ngram is.
+type Jaccard struct{}
+
+func (Jaccard) Score(stats ScoreStats, matches []NgramMatch) float64 {
+	var intersection uint32
+	for _, m := range matches {
+		if m.QueryFreq < m.TermFreq {
+			intersection += m.QueryFreq
+		} else {
+			intersection += m.TermFreq
+		}
+	}
+
+	union := stats.QueryLen + stats.DocLen - intersection
+	if union == 0 {
+		return 0
+	}
+	return float64(intersection) / float64(union)
+}
+
+// DefaultNameScorer is the scorer used when a query does not pick one
+var DefaultNameScorer NameScorer = OkapiBM25{K1: 1.2, B: 0.75}
+
+// ScorerByName returns the scorer with the given name, one of "bm25",
+// "tfidf" or "jaccard"
+func ScorerByName(name string) (NameScorer, error) {
+	switch name {
+	case "bm25", "okapibm25":
+		return DefaultNameScorer, nil
+	case "tfidf":
+		return TFIDF{}, nil
+	case "jaccard":
+		return Jaccard{}, nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrorUnknownScorer, name)
+}
@@ -0,0 +1,71 @@
+package imdb
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestScorerByName(t *testing.T) {
+	for _, name := range []string{"bm25", "tfidf", "jaccard"} {
+		if _, err := ScorerByName(name); err != nil {
+			t.Fatalf("failed to get scorer %q: %v", name, err)
+		}
+	}
+
+	if _, err := ScorerByName("pagerank"); !errors.Is(err, ErrorUnknownScorer) {
+		t.Fatalf("expected unknown scorer error, got %v", err)
+	}
+}
+
+func TestJaccard(t *testing.T) {
+	stats := ScoreStats{NumDocs: 10, AvgLen: 4, QueryLen: 4, DocLen: 6}
+	matches := []NgramMatch{
+		{QueryFreq: 1, TermFreq: 2, DocCount: 3},
+		{QueryFreq: 2, TermFreq: 1, DocCount: 3},
+	}
+
+	// intersection of 2 over a union of 4 + 6 - 2
+	if got := (Jaccard{}).Score(stats, matches); math.Abs(got-0.25) > 1e-9 {
+		t.Fatalf("incorrect jaccard score: got=%f want=%f", got, 0.25)
+	}
+}
+
+func TestBM25PrefersRareAndShort(t *testing.T) {
+	scorer := DefaultNameScorer
+	stats := ScoreStats{NumDocs: 1000, AvgLen: 10, QueryLen: 3, DocLen: 10}
+
+	common := scorer.Score(stats, []NgramMatch{{QueryFreq: 1, TermFreq: 1, DocCount: 500}})
+	rare := scorer.Score(stats, []NgramMatch{{QueryFreq: 1, TermFreq: 1, DocCount: 5}})
+	if rare <= common {
+		t.Fatalf("rare ngram should outscore common: rare=%f common=%f", rare, common)
+	}
+
+	stats.DocLen = 30
+	long := scorer.Score(stats, []NgramMatch{{QueryFreq: 1, TermFreq: 1, DocCount: 5}})
+	if long >= rare {
+		t.Fatalf("short document should outscore long: short=%f long=%f", rare, long)
+	}
+}
+
+// index gets setup in episode_test.go:TestMain
+func TestNameSearchScorers(t *testing.T) {
+	idx, err := nameOpen(tmpDir)
+	if err != nil {
+		t.Fatalf("failed to open name index: %v", err)
+	}
+
+	for _, name := range []string{"bm25", "tfidf", "jaccard"} {
+		scorer, err := ScorerByName(name)
+		if err != nil {
+			t.Fatalf("failed to get scorer %q: %v", name, err)
+		}
+		results, err := idx.Search(&NameQuery{Name: "simpsons", Scorer: scorer})
+		if err != nil {
+			t.Fatalf("failed to search with %q: %v", name, err)
+		}
+		if len(results) == 0 || results[0].Id != "tt0096697" {
+			t.Fatalf("incorrect top result with %q: %+v", name, results)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package imdb
+
+import (
+	"fmt"
+
+	"github.com/jbpratt78/imdb-index/types"
+)
+
+// candidateFactor is how many times the requested size of name matches is
+// retrieved, leaving room for the matches removed by the query's filters
+const candidateFactor = 10
+
+// Searcher executes queries across the title, name, ratings and episode
+// indices
+type Searcher struct {
+	titles   *TitleIndex
+	names    *NameIndex
+	ratings  *RatingsIndex
+	episodes *EpisodeIndex
+}
+
+// SearchResult is a single title matching a query, joined with its rating and
+// episode records
+type SearchResult struct {
+	// The relevance of the result, higher is better.
+	Score float64      `json:"score"`
+	Title *types.Title `json:"title"`
+	// The rating of the title, nil if it has none.
+	Rating *types.Rating `json:"rating,omitempty"`
+	// The episode record of the title, nil if it is not an episode.
+	Episode *types.Episode `json:"episode,omitempty"`
+}
+
+// NewSearcher returns a searcher over the given indices
+func NewSearcher(
+	titles *TitleIndex,
+	names *NameIndex,
+	ratings *RatingsIndex,
+	episodes *EpisodeIndex,
+) *Searcher {
+	return &Searcher{titles, names, ratings, episodes}
+}
+
+type candidate struct {
+	id    string
+	score float64
+}
+
+// Search returns the titles matching the query, best first. A query needs
+// either a name or a TV show id; without a name every episode of the show is
+// a candidate.
+func (s *Searcher) Search(q *types.Query) ([]*SearchResult, error) {
+	size := int(q.Size)
+	if size <= 0 {
+		size = 30
+	}
+
+	var candidates []candidate
+	switch {
+	case q.Name != "":
+		nq := &NameQuery{Name: q.Name, Size: size * candidateFactor}
+		if q.NameScorer != "" {
+			scorer, err := ScorerByName(q.NameScorer)
+			if err != nil {
+				return nil, err
+			}
+			nq.Scorer = scorer
+		}
+		if q.Similarity != "" {
+			sim, err := ParseSimilarity(q.Similarity)
+			if err != nil {
+				return nil, err
+			}
+			nq.Similarity = sim
+		}
+
+		names, err := s.names.Search(nq)
+		if err != nil {
+			return nil, fmt.Errorf("failed to search names: %w", err)
+		}
+		for _, n := range names {
+			candidates = append(candidates, candidate{n.Id, n.Score})
+		}
+	case q.TvShowID != "":
+		eps, err := s.episodes.ShowEpisodes([]byte(q.TvShowID))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list episodes of %q: %w", q.TvShowID, err)
+		}
+		for _, ep := range eps {
+			candidates = append(candidates, candidate{ep.Id, 1})
+		}
+	default:
+		return nil, QueryError("query needs a name or a show id")
+	}
+
+	var results []*SearchResult
+	for _, c := range candidates {
+		if len(results) == size {
+			break
+		}
+		r, err := s.join(q, c)
+		if err != nil {
+			return nil, err
+		}
+		if r != nil {
+			results = append(results, r)
+		}
+	}
+	return results, nil
+}
+
+// join looks up the records of a candidate and returns nil if they do not
+// satisfy the query
+func (s *Searcher) join(q *types.Query, c candidate) (*SearchResult, error) {
+	title, ok, err := s.titles.Lookup([]byte(c.id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get title %q: %w", c.id, err)
+	}
+	if !ok || !matchesKind(q.Kinds, title.Kind) || !q.Year.Contains(title.StartYear) {
+		return nil, nil
+	}
+
+	rating, ok, err := s.ratings.Lookup([]byte(c.id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rating of %q: %w", c.id, err)
+	}
+	if !ok {
+		rating = nil
+		if !q.Votes.IsUnbounded() {
+			return nil, nil
+		}
+	} else if !q.Votes.Contains(rating.Votes) {
+		return nil, nil
+	}
+
+	needsEpisode := q.TvShowID != "" || !q.Season.IsUnbounded() || !q.Episode.IsUnbounded()
+	var ep *types.Episode
+	if needsEpisode || title.Kind == types.TVEpisode {
+		ep, ok, err = s.episodes.Lookup([]byte(c.id))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get episode %q: %w", c.id, err)
+		}
+		if !ok {
+			ep = nil
+			if needsEpisode {
+				return nil, nil
+			}
+		} else if (q.TvShowID != "" && ep.TvShowID != q.TvShowID) ||
+			!containsNumber(q.Season, ep.Season) || !containsNumber(q.Episode, ep.Episode) {
+			return nil, nil
+		}
+	}
+
+	return &SearchResult{Score: c.score, Title: title, Rating: rating, Episode: ep}, nil
+}
+
+// containsNumber reports whether a season or episode number is within r,
+// where an unknown number is only within an unbounded range
+func containsNumber(r types.Range, n uint32) bool {
+	if n == types.UnknownNumber {
+		return r.IsUnbounded()
+	}
+	return r.Contains(n)
+}
+
+func matchesKind(kinds []types.TitleKind, kind types.TitleKind) bool {
+	if len(kinds) == 0 {
+		return true
+	}
+	for _, k := range kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
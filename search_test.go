@@ -0,0 +1,102 @@
+package imdb
+
+import (
+	"testing"
+
+	"github.com/jbpratt78/imdb-index/types"
+)
+
+// index gets setup in episode_test.go:TestMain
+func openTestSearcher(t *testing.T) *Searcher {
+	titles, err := titleOpen(tmpDir)
+	if err != nil {
+		t.Fatalf("failed to open title index: %v", err)
+	}
+	names, err := nameOpen(tmpDir)
+	if err != nil {
+		t.Fatalf("failed to open name index: %v", err)
+	}
+	ratings, err := ratingsOpen(tmpDir)
+	if err != nil {
+		t.Fatalf("failed to open ratings index: %v", err)
+	}
+	episodes, err := episodeOpen(tmpDir)
+	if err != nil {
+		t.Fatalf("failed to open episode index: %v", err)
+	}
+	return NewSearcher(titles, names, ratings, episodes)
+}
+
+func search(t *testing.T, s *Searcher, query string) []*SearchResult {
+	q, err := ParseQuery(query)
+	if err != nil {
+		t.Fatalf("failed to parse %q: %v", query, err)
+	}
+	results, err := s.Search(q)
+	if err != nil {
+		t.Fatalf("failed to search %q: %v", query, err)
+	}
+	return results
+}
+
+func TestSearchShow(t *testing.T) {
+	results := search(t, openTestSearcher(t), "simpsns {show} {votes:1000-}")
+
+	if len(results) != 1 {
+		t.Fatalf("got the wrong amount of results: got=%d want=%d", len(results), 1)
+	}
+	r := results[0]
+	if r.Title.Id != "tt0096697" || r.Title.Kind != types.TVSeries {
+		t.Fatalf("incorrect title: %+v", r.Title)
+	}
+	if r.Rating == nil || r.Rating.Votes != 375631 {
+		t.Fatalf("incorrect rating: %+v", r.Rating)
+	}
+	if r.Episode != nil {
+		t.Fatalf("show should not have an episode: %+v", r.Episode)
+	}
+}
+
+func TestSearchEpisodeNumbers(t *testing.T) {
+	results := search(t, openTestSearcher(t), "{show:tt0096697} {s:2} {e:7}")
+
+	if len(results) != 1 {
+		t.Fatalf("got the wrong amount of results: got=%d want=%d", len(results), 1)
+	}
+	r := results[0]
+	if r.Title.Title != "Bart vs. Thanksgiving" {
+		t.Fatalf("incorrect title: %+v", r.Title)
+	}
+	if r.Episode == nil || r.Episode.Season != 2 || r.Episode.Episode != 7 {
+		t.Fatalf("incorrect episode: %+v", r.Episode)
+	}
+	if r.Rating == nil || r.Rating.Rating != 7.8 {
+		t.Fatalf("incorrect rating: %+v", r.Rating)
+	}
+}
+
+func TestSearchFilters(t *testing.T) {
+	results := search(t, openTestSearcher(t), "bart {episode} {year:1991} {s:2-}")
+
+	if len(results) == 0 {
+		t.Fatalf("got no results")
+	}
+	for _, r := range results {
+		if r.Title.Kind != types.TVEpisode || r.Title.StartYear != 1991 {
+			t.Fatalf("result does not match filters: %+v", r.Title)
+		}
+		if r.Episode == nil || r.Episode.Season < 2 {
+			t.Fatalf("result does not match season: %+v", r.Episode)
+		}
+	}
+}
+
+func TestSearchNeedsNameOrShow(t *testing.T) {
+	q, err := ParseQuery("{year:1990}")
+	if err != nil {
+		t.Fatalf("failed to parse query: %v", err)
+	}
+	if _, err := openTestSearcher(t).Search(q); err == nil {
+		t.Fatalf("expected error for query without a name or show")
+	}
+}
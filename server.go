@@ -0,0 +1,132 @@
+package imdb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Server serves an Index as a JSON API over HTTP:
+//
+//	GET /titles/{id}              the title record
+//	GET /ratings/{id}             the rating of a title
+//	GET /episodes/{id}            the episode record of an episode
+//	GET /shows/{id}/seasons       the seasons of a TV show
+//	GET /shows/{id}/seasons/{n}   the episodes of a season of a TV show
+//	GET /akas/{id}                the alternate names of a title
+//	GET /search?q={query}         search results, see ParseQuery
+type Server struct {
+	// acquire returns the index a request is served from and the function
+	// releasing it once the response is written
+	acquire func() (*Index, func())
+}
+
+// NewServer returns a server over an open index. The index must stay open
+// for as long as the server handles requests.
+func NewServer(idx *Index) *Server {
+	return &Server{func() (*Index, func()) { return idx, func() {} }}
+}
+
+// NewHolderServer returns a server over the index of a holder. Every
+// request is served from the index current when it arrives, so reloads take
+// effect without disturbing requests in flight.
+func NewHolderServer(h *Holder) *Server {
+	return &Server{h.Acquire}
+}
+
+type httpError struct {
+	status int
+	msg    string
+}
+
+func (e *httpError) Error() string { return e.msg }
+
+func notFound(format string, args ...interface{}) error {
+	return &httpError{http.StatusNotFound, fmt.Sprintf(format, args...)}
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &httpError{http.StatusBadRequest, fmt.Sprintf(format, args...)}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeJSONError(w, &httpError{http.StatusMethodNotAllowed, "method not allowed"})
+		return
+	}
+
+	idx, release := s.acquire()
+	defer release()
+	v, err := route(idx, r)
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, v)
+}
+
+func route(idx *Index, r *http.Request) (interface{}, error) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(parts) == 2 && parts[0] == "titles":
+		return idx.titles.Title([]byte(parts[1]))
+	case len(parts) == 2 && parts[0] == "ratings":
+		return idx.ratings.Rating([]byte(parts[1]))
+	case len(parts) == 2 && parts[0] == "episodes":
+		return idx.episodes.Episode([]byte(parts[1]))
+	case len(parts) == 3 && parts[0] == "shows" && parts[2] == "seasons":
+		seasons, err := idx.episodes.Seasons([]byte(parts[1]))
+		if err == nil && len(seasons) == 0 {
+			err = notFound("no seasons of %q", parts[1])
+		}
+		return seasons, err
+	case len(parts) == 4 && parts[0] == "shows" && parts[2] == "seasons":
+		season, err := strconv.ParseUint(parts[3], 10, 32)
+		if err != nil {
+			return nil, badRequest("invalid season %q", parts[3])
+		}
+		eps, err := idx.episodes.Episodes([]byte(parts[1]), uint32(season))
+		if err == nil && len(eps) == 0 {
+			err = notFound("no season %d of %q", season, parts[1])
+		}
+		return eps, err
+	case len(parts) == 2 && parts[0] == "akas":
+		return idx.akas.Find([]byte(parts[1]))
+	case len(parts) == 1 && parts[0] == "search":
+		q, err := ParseQuery(r.URL.Query().Get("q"))
+		if err != nil {
+			return nil, badRequest("%v", err)
+		}
+		results, err := idx.Searcher().Search(q)
+		var qerr QueryError
+		if errors.As(err, &qerr) {
+			return nil, badRequest("%v", err)
+		}
+		if results == nil {
+			results = []*SearchResult{}
+		}
+		return results, err
+	}
+	return nil, notFound("no route for %s", r.URL.Path)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var herr *httpError
+	if errors.As(err, &herr) {
+		status = herr.status
+	} else if errors.Is(err, ErrNotFound) {
+		status = http.StatusNotFound
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
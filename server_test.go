@@ -0,0 +1,116 @@
+package imdb
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/jbpratt78/imdb-index/types"
+)
+
+func get(t *testing.T, srv *httptest.Server, path string, want int, v interface{}) {
+	resp, err := http.Get(srv.URL + path)
+	if err != nil {
+		t.Fatalf("failed to get %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != want {
+		t.Fatalf("incorrect status for %s: got=%d want=%d", path, resp.StatusCode, want)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("incorrect content type for %s: %q", path, ct)
+	}
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("failed to decode %s: %v", path, err)
+		}
+	}
+}
+
+// index gets setup in episode_test.go:TestMain
+func TestServer(t *testing.T) {
+	idx, err := Open(tmpDir)
+	if err != nil {
+		t.Fatalf("failed to open index: %v", err)
+	}
+	defer idx.Close()
+
+	srv := httptest.NewServer(NewServer(idx))
+	defer srv.Close()
+
+	var title types.Title
+	get(t, srv, "/titles/tt0096697", http.StatusOK, &title)
+	if title.Title != "The Simpsons" || title.Kind != types.TVSeries {
+		t.Fatalf("incorrect title: %+v", title)
+	}
+
+	var rating types.Rating
+	get(t, srv, "/ratings/tt0701062", http.StatusOK, &rating)
+	if rating.Votes != 2852 {
+		t.Fatalf("incorrect rating: %+v", rating)
+	}
+
+	var ep types.Episode
+	get(t, srv, "/episodes/tt0701063", http.StatusOK, &ep)
+	if ep.TvShowID != "tt0096697" || ep.Season != 2 {
+		t.Fatalf("incorrect episode: %+v", ep)
+	}
+
+	var seasons []Season
+	get(t, srv, "/shows/tt0096697/seasons", http.StatusOK, &seasons)
+	if len(seasons) != 3 || seasons[1] != (Season{2, 22}) {
+		t.Fatalf("incorrect seasons: %+v", seasons)
+	}
+
+	var eps []types.Episode
+	get(t, srv, "/shows/tt0096697/seasons/2", http.StatusOK, &eps)
+	if len(eps) != 22 {
+		t.Fatalf("got the wrong amount of episodes: got=%d want=%d", len(eps), 22)
+	}
+
+	var akas []types.Aka
+	get(t, srv, "/akas/tt0096697", http.StatusOK, &akas)
+	if len(akas) != 38 {
+		t.Fatalf("got the wrong amount of akas: got=%d want=%d", len(akas), 38)
+	}
+
+	var results []SearchResult
+	get(t, srv, "/search?q="+url.QueryEscape("simpsns {show}"), http.StatusOK, &results)
+	if len(results) != 1 || results[0].Title.Id != "tt0096697" {
+		t.Fatalf("incorrect results: %+v", results)
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	idx, err := Open(tmpDir)
+	if err != nil {
+		t.Fatalf("failed to open index: %v", err)
+	}
+	defer idx.Close()
+
+	srv := httptest.NewServer(NewServer(idx))
+	defer srv.Close()
+
+	get(t, srv, "/titles/tt9999999", http.StatusNotFound, nil)
+	get(t, srv, "/ratings/tt9999999", http.StatusNotFound, nil)
+	get(t, srv, "/episodes/tt0096697", http.StatusNotFound, nil)
+	get(t, srv, "/shows/tt0096697/seasons/99", http.StatusNotFound, nil)
+	get(t, srv, "/shows/tt009669/seasons", http.StatusNotFound, nil)
+	get(t, srv, "/shows/tt0096697/seasons/two", http.StatusBadRequest, nil)
+	get(t, srv, "/akas/tt9999999", http.StatusNotFound, nil)
+	get(t, srv, "/search?q="+url.QueryEscape("{bogus}"), http.StatusBadRequest, nil)
+	get(t, srv, "/search", http.StatusBadRequest, nil)
+	get(t, srv, "/nothing", http.StatusNotFound, nil)
+
+	resp, err := http.Post(srv.URL+"/titles/tt0096697", "application/json", nil)
+	if err != nil {
+		t.Fatalf("failed to post: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("incorrect status for post: %d", resp.StatusCode)
+	}
+}
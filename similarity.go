@@ -0,0 +1,141 @@
+package imdb
+
+import "fmt"
+
+// Similarity is a string similarity function used to re-rank the candidates
+// of a name search
+type Similarity string
+
+const (
+	SimilarityNone        Similarity = "none"
+	SimilarityLevenshtein Similarity = "levenshtein"
+	SimilarityJaro        Similarity = "jaro"
+	SimilarityJaroWinkler Similarity = "jaro-winkler"
+)
+
+// ParseSimilarity returns the Similarity with the given name
+func ParseSimilarity(name string) (Similarity, error) {
+	switch s := Similarity(name); s {
+	case SimilarityNone, SimilarityLevenshtein, SimilarityJaro, SimilarityJaroWinkler:
+		return s, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrorUnknownSimilarity, name)
+}
+
+// Compare returns the similarity of two strings from 0 (nothing in common) to
+// 1 (identical). SimilarityNone considers every pair of strings identical.
+func (s Similarity) Compare(a, b string) float64 {
+	switch s {
+	case SimilarityLevenshtein:
+		x, y := []rune(a), []rune(b)
+		longest := len(x)
+		if len(y) > longest {
+			longest = len(y)
+		}
+		if longest == 0 {
+			return 1
+		}
+		return 1 - float64(levenshtein(x, y))/float64(longest)
+	case SimilarityJaro:
+		return jaro([]rune(a), []rune(b))
+	case SimilarityJaroWinkler:
+		return jaroWinkler([]rune(a), []rune(b))
+	}
+	return 1
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(minInt(prev[j]+1, cur[j-1]+1), prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func jaro(a, b []rune) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 1
+	}
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+
+	window := len(a)
+	if len(b) > window {
+		window = len(b)
+	}
+	window = window/2 - 1
+	if window < 0 {
+		window = 0
+	}
+
+	aMatched := make([]bool, len(a))
+	bMatched := make([]bool, len(b))
+	matches := 0
+	for i := range a {
+		lo, hi := i-window, i+window+1
+		if lo < 0 {
+			lo = 0
+		}
+		if hi > len(b) {
+			hi = len(b)
+		}
+		for j := lo; j < hi; j++ {
+			if !bMatched[j] && a[i] == b[j] {
+				aMatched[i], bMatched[j] = true, true
+				matches++
+				break
+			}
+		}
+	}
+	if matches == 0 {
+		return 0
+	}
+
+	transpositions := 0
+	j := 0
+	for i := range a {
+		if !aMatched[i] {
+			continue
+		}
+		for !bMatched[j] {
+			j++
+		}
+		if a[i] != b[j] {
+			transpositions++
+		}
+		j++
+	}
+
+	m := float64(matches)
+	return (m/float64(len(a)) + m/float64(len(b)) + (m-float64(transpositions)/2)/m) / 3
+}
+
+func jaroWinkler(a, b []rune) float64 {
+	sim := jaro(a, b)
+
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && prefix < 4 && a[prefix] == b[prefix] {
+		prefix++
+	}
+	return sim + float64(prefix)*0.1*(1-sim)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
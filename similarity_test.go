@@ -0,0 +1,68 @@
+package imdb
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestSimilarity(t *testing.T) {
+	tests := []struct {
+		sim  Similarity
+		a, b string
+		want float64
+	}{
+		{SimilarityNone, "abc", "xyz", 1},
+		{SimilarityLevenshtein, "kitten", "sitting", 1 - 3.0/7},
+		{SimilarityLevenshtein, "", "", 1},
+		{SimilarityJaro, "martha", "marhta", 0.944444},
+		{SimilarityJaro, "abc", "xyz", 0},
+		{SimilarityJaroWinkler, "martha", "marhta", 0.961111},
+		{SimilarityJaroWinkler, "dixon", "dicksonx", 0.813333},
+	}
+
+	for _, tt := range tests {
+		got := tt.sim.Compare(tt.a, tt.b)
+		if math.Abs(got-tt.want) > 1e-5 {
+			t.Fatalf("incorrect %s similarity of %q and %q: got=%f want=%f", tt.sim, tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestParseSimilarity(t *testing.T) {
+	if s, err := ParseSimilarity("jaro-winkler"); err != nil || s != SimilarityJaroWinkler {
+		t.Fatalf("failed to parse similarity: %q %v", s, err)
+	}
+	if _, err := ParseSimilarity("cosine"); !errors.Is(err, ErrorUnknownSimilarity) {
+		t.Fatalf("expected unknown similarity error, got %v", err)
+	}
+}
+
+// index gets setup in episode_test.go:TestMain
+func TestNameSearchSimilarity(t *testing.T) {
+	idx, err := nameOpen(tmpDir)
+	if err != nil {
+		t.Fatalf("failed to open name index: %v", err)
+	}
+
+	results, err := idx.Search(&NameQuery{
+		Name:       "the war of the simpsons",
+		Size:       3,
+		Similarity: SimilarityLevenshtein,
+	})
+	if err != nil {
+		t.Fatalf("failed to search names: %v", err)
+	}
+
+	if len(results) == 0 || results[0].Id != "tt0766140" {
+		t.Fatalf("incorrect results: %+v", results)
+	}
+	if results[0].Score != 1 {
+		t.Fatalf("exact match should have a similarity of 1: %f", results[0].Score)
+	}
+	for i := 1; i < len(results); i++ {
+		if results[i].Score > results[i-1].Score {
+			t.Fatalf("results are not ordered by similarity: %+v", results)
+		}
+	}
+}
@@ -0,0 +1,374 @@
+package imdb
+
+import (
+	"bufio"
+	"compress/gzip"
+	"container/heap"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SortOptions controls the external sort of a dataset
+type SortOptions struct {
+	// The approximate number of bytes of lines held in memory before a
+	// sorted run is spilled to a temporary file.
+	MemoryBudget int
+	// The directory holding the spilled runs. Defaults to os.TempDir.
+	TempDir string
+}
+
+// DefaultSortOptions sorts with a 256MB memory budget in os.TempDir
+var DefaultSortOptions = SortOptions{MemoryBudget: 256 << 20}
+
+// sortSpec describes how the lines of a dataset are ordered for its index
+type sortSpec struct {
+	// The columns compared, in order, to sort lines. Lines equal on every
+	// key are ordered by their full text.
+	keys []sortKey
+	// Whether several lines may share an identifier. When false only the
+	// first line of every identifier is kept.
+	duplicates bool
+}
+
+// sortKey is a column of a sort specification
+type sortKey struct {
+	column int
+	// Whether the column holds numbers, which are compared by value. Lines
+	// without a number in the column sort after those with one.
+	numeric bool
+}
+
+// datasetSorts holds the sort specification of every dataset. Only the akas
+// have several lines per title, which the akas index expects to be grouped
+// and in order of preference.
+var datasetSorts = map[string]sortSpec{
+	IMDBBasics:  {keys: []sortKey{{column: 0}}},
+	IMDBEpisode: {keys: []sortKey{{column: 0}}},
+	IMDBRatings: {keys: []sortKey{{column: 0}}},
+	IMDBAKAS:    {keys: []sortKey{{column: 0}, {column: 1, numeric: true}}, duplicates: true},
+}
+
+// defaultSort orders lines by identifier and keeps one line per identifier
+var defaultSort = sortSpec{keys: []sortKey{{column: 0}}}
+
+// sortSpecFor returns the sort specification of the named dataset
+func sortSpecFor(dataset string) sortSpec {
+	if spec, ok := datasetSorts[dataset]; ok {
+		return spec
+	}
+	return defaultSort
+}
+
+// less reports whether line a sorts before line b
+func (s sortSpec) less(a, b string) bool {
+	for _, k := range s.keys {
+		x, y := column(a, k.column), column(b, k.column)
+		if x == y {
+			continue
+		}
+		if !k.numeric {
+			return x < y
+		}
+
+		nx, errx := strconv.ParseUint(x, 10, 64)
+		ny, erry := strconv.ParseUint(y, 10, 64)
+		switch {
+		case errx == nil && erry == nil:
+			if nx != ny {
+				return nx < ny
+			}
+		case errx == nil:
+			return true
+		case erry == nil:
+			return false
+		default:
+			return x < y
+		}
+	}
+	return a < b
+}
+
+// column returns the i-th tab separated column of line, or the empty string
+// if the line is too short
+func column(line string, i int) string {
+	for ; i > 0; i-- {
+		tab := strings.IndexByte(line, '\t')
+		if tab < 0 {
+			return ""
+		}
+		line = line[tab+1:]
+	}
+	if tab := strings.IndexByte(line, '\t'); tab >= 0 {
+		return line[:tab]
+	}
+	return line
+}
+
+// lineOverhead approximates the bookkeeping cost of holding a line in memory
+// beyond its bytes: the string header and its slot in the slice.
+const lineOverhead = 32
+
+// Sort all CSV records according to the dataset's sort specification, which
+// for every dataset puts the `tt` identifiers in lexicographic order.
+//
+// This is unfortunately necessary because the IMDb data is no longer sorted
+// in lexicographic order with respect to the `tt` identifiers. This appears
+// to be fallout as a result of adding 10 character identifiers (previously,
+// only 9 character identifiers were used).
+//
+// The datasets are too large to sort in memory, so lines are gathered until
+// the memory budget is reached, sorted and spilled to a temporary file as a
+// gzip compressed run, so that a sort never needs the uncompressed size of a
+// dataset on disk. The runs are then merged into out. The header line is
+// written first and, unless the specification allows duplicates, only the
+// first line of every identifier is kept. Every line read is counted by p,
+// which stops the sort once its context is done.
+func writeSortedCSVRecords(in io.Reader, out io.Writer, spec sortSpec, opts SortOptions, p *progress) error {
+	// We actually only sort the raw lines here instead of parsing CSV records,
+	// since parsing into CSV records has fairly substantial memory overhead.
+	// Since IMDb CSV data never contains a record that spans multiple lines,
+	// this transformation is okay.
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	if !scanner.Scan() {
+		return scanner.Err()
+	}
+	header := scanner.Text()
+
+	if opts.MemoryBudget <= 0 {
+		opts.MemoryBudget = DefaultSortOptions.MemoryBudget
+	}
+	tmpDir, err := ioutil.TempDir(opts.TempDir, "imdb-sort")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var runs []string
+	var lines []string
+	size := 0
+	for scanner.Scan() {
+		line := scanner.Text()
+		if err := p.add(1); err != nil {
+			return err
+		}
+		lines = append(lines, line)
+		size += len(line) + lineOverhead
+		if size >= opts.MemoryBudget {
+			run, err := spillRun(tmpDir, lines, spec)
+			if err != nil {
+				return err
+			}
+			runs = append(runs, run)
+			lines, size = nil, 0
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	w := newDedupWriter(out, header, !spec.duplicates)
+
+	// everything fit in memory, so skip the round trip through disk
+	if len(runs) == 0 {
+		sortLines(lines, spec)
+		for _, line := range lines {
+			if err := w.write(line); err != nil {
+				return err
+			}
+		}
+		return w.flush()
+	}
+
+	if len(lines) > 0 {
+		run, err := spillRun(tmpDir, lines, spec)
+		if err != nil {
+			return err
+		}
+		runs = append(runs, run)
+		lines = nil
+	}
+
+	merged := 0
+	err = mergeRuns(runs, spec, func(line string) error {
+		if merged++; merged%progressInterval == 0 {
+			if err := p.err(); err != nil {
+				return err
+			}
+		}
+		return w.write(line)
+	})
+	if err != nil {
+		return err
+	}
+	return w.flush()
+}
+
+func sortLines(lines []string, spec sortSpec) {
+	sort.Slice(lines, func(i, j int) bool { return spec.less(lines[i], lines[j]) })
+}
+
+// spillRun sorts lines and writes them to a new compressed file in dir
+func spillRun(dir string, lines []string, spec sortSpec) (string, error) {
+	sortLines(lines, spec)
+
+	f, err := ioutil.TempFile(dir, "run")
+	if err != nil {
+		return "", err
+	}
+	zw, err := gzip.NewWriterLevel(f, gzip.BestSpeed)
+	if err != nil {
+		f.Close()
+		return "", err
+	}
+	w := bufio.NewWriter(zw)
+	for _, line := range lines {
+		if _, err = w.WriteString(line); err != nil {
+			f.Close()
+			return "", err
+		}
+		if err = w.WriteByte('\n'); err != nil {
+			f.Close()
+			return "", err
+		}
+	}
+	if err = w.Flush(); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err = zw.Close(); err != nil {
+		f.Close()
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
+
+// mergeRuns calls fn with the lines of every sorted run in sorted order
+func mergeRuns(runs []string, spec sortSpec, fn func(line string) error) error {
+	h := &runHeap{spec: spec}
+	for i, run := range runs {
+		f, err := openGzip(run)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		r := &runReader{scanner: bufio.NewScanner(f), index: i}
+		r.scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		ok, err := r.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			h.readers = append(h.readers, r)
+		}
+	}
+	heap.Init(h)
+
+	for h.Len() > 0 {
+		r := h.readers[0]
+		if err := fn(r.line); err != nil {
+			return err
+		}
+		ok, err := r.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+	return nil
+}
+
+type runReader struct {
+	scanner *bufio.Scanner
+	line    string
+	index   int
+}
+
+func (r *runReader) next() (bool, error) {
+	if r.scanner.Scan() {
+		r.line = r.scanner.Text()
+		return true, nil
+	}
+	return false, r.scanner.Err()
+}
+
+// runHeap orders run readers by their current line, then by run so that
+// merging is stable
+type runHeap struct {
+	readers []*runReader
+	spec    sortSpec
+}
+
+func (h *runHeap) Len() int { return len(h.readers) }
+
+func (h *runHeap) Less(i, j int) bool {
+	if h.readers[i].line != h.readers[j].line {
+		return h.spec.less(h.readers[i].line, h.readers[j].line)
+	}
+	return h.readers[i].index < h.readers[j].index
+}
+
+func (h *runHeap) Swap(i, j int) { h.readers[i], h.readers[j] = h.readers[j], h.readers[i] }
+
+func (h *runHeap) Push(x interface{}) { h.readers = append(h.readers, x.(*runReader)) }
+
+func (h *runHeap) Pop() interface{} {
+	old := h.readers
+	r := old[len(old)-1]
+	h.readers = old[:len(old)-1]
+	return r
+}
+
+// dedupWriter writes the header followed by sorted lines, dropping every line
+// whose identifier equals that of the previous line when dedup is set
+type dedupWriter struct {
+	w     *bufio.Writer
+	dedup bool
+	prev  string
+	err   error
+}
+
+func newDedupWriter(out io.Writer, header string, dedup bool) *dedupWriter {
+	d := &dedupWriter{w: bufio.NewWriter(out), dedup: dedup}
+	d.err = d.writeLine(header)
+	return d
+}
+
+func (d *dedupWriter) write(line string) error {
+	if d.err != nil {
+		return d.err
+	}
+	if d.dedup {
+		first := column(line, 0)
+		if first == d.prev {
+			return nil
+		}
+		d.prev = first
+	}
+	d.err = d.writeLine(line)
+	return d.err
+}
+
+func (d *dedupWriter) writeLine(line string) error {
+	if _, err := d.w.WriteString(line); err != nil {
+		return err
+	}
+	return d.w.WriteByte('\n')
+}
+
+func (d *dedupWriter) flush() error {
+	if d.err != nil {
+		return d.err
+	}
+	return d.w.Flush()
+}
@@ -0,0 +1,115 @@
+package imdb
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestWriteSortedCSVRecords(t *testing.T) {
+	in := "tconst\tvalue\n" +
+		"tt03\tc\n" +
+		"tt01\ta\n" +
+		"tt02\tb\n" +
+		"tt01\tz\n"
+	want := "tconst\tvalue\n" +
+		"tt01\ta\n" +
+		"tt02\tb\n" +
+		"tt03\tc\n"
+
+	var out bytes.Buffer
+	if err := writeSortedCSVRecords(strings.NewReader(in), &out, defaultSort, DefaultSortOptions, nil); err != nil {
+		t.Fatalf("failed to sort: %v", err)
+	}
+	if out.String() != want {
+		t.Fatalf("incorrect output: got=%q want=%q", out.String(), want)
+	}
+}
+
+func TestWriteSortedCSVRecordsSpills(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imdb-sort-test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var lines []string
+	for _, i := range rand.New(rand.NewSource(1)).Perm(1000) {
+		lines = append(lines, fmt.Sprintf("tt%07d\t%d", i, i))
+		if i%10 == 0 {
+			// duplicate ids only keep their smallest line
+			lines = append(lines, fmt.Sprintf("tt%07d\tz", i))
+		}
+	}
+	in := "tconst\tvalue\n" + strings.Join(lines, "\n") + "\n"
+
+	var want []string
+	for i := 0; i < 1000; i++ {
+		want = append(want, fmt.Sprintf("tt%07d\t%d", i, i))
+	}
+	sort.Strings(want)
+
+	// a tiny budget forces a run every few lines
+	var out bytes.Buffer
+	opts := SortOptions{MemoryBudget: 256, TempDir: dir}
+	if err := writeSortedCSVRecords(strings.NewReader(in), &out, defaultSort, opts, nil); err != nil {
+		t.Fatalf("failed to sort: %v", err)
+	}
+
+	got := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if got[0] != "tconst\tvalue" {
+		t.Fatalf("incorrect header: %q", got[0])
+	}
+	if strings.Join(got[1:], "\n") != strings.Join(want, "\n") {
+		t.Fatalf("incorrect output, got %d lines want %d", len(got)-1, len(want))
+	}
+
+	// runs are removed once merged
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read temp dir: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("temporary runs were left behind: %d", len(entries))
+	}
+}
+
+func TestWriteSortedAkasKeepsEveryRow(t *testing.T) {
+	in := "titleId\tordering\ttitle\n" +
+		"tt02\t1\tb\n" +
+		"tt01\t10\tj\n" +
+		"tt01\t2\tb\n" +
+		"tt01\t1\ta\n" +
+		"tt01\t\\N\tx\n"
+	want := "titleId\tordering\ttitle\n" +
+		"tt01\t1\ta\n" +
+		"tt01\t2\tb\n" +
+		"tt01\t10\tj\n" +
+		"tt01\t\\N\tx\n" +
+		"tt02\t1\tb\n"
+
+	for _, budget := range []int{DefaultSortOptions.MemoryBudget, 1} {
+		var out bytes.Buffer
+		opts := SortOptions{MemoryBudget: budget}
+		if err := writeSortedCSVRecords(strings.NewReader(in), &out, sortSpecFor(IMDBAKAS), opts, nil); err != nil {
+			t.Fatalf("failed to sort: %v", err)
+		}
+		if out.String() != want {
+			t.Fatalf("incorrect output with budget %d: got=%q want=%q", budget, out.String(), want)
+		}
+	}
+}
+
+func TestColumn(t *testing.T) {
+	line := "tt01\t2\tname"
+	for i, want := range []string{"tt01", "2", "name", ""} {
+		if got := column(line, i); got != want {
+			t.Fatalf("incorrect column %d: got=%q want=%q", i, got, want)
+		}
+	}
+}
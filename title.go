@@ -0,0 +1,207 @@
+package imdb
+
+import (
+	"fmt"
+	"io"
+	"path"
+
+	"github.com/couchbase/vellum"
+	"github.com/jbpratt78/imdb-index/types"
+)
+
+const (
+	TITLES       = "title.fst"
+	TITLERECORDS = "title.records"
+)
+
+type TitleError string
+
+func (e TitleError) Error() string { return string(e) }
+
+// TitleIndex maps IMDb identifiers to the address of their record in a
+// store of the basics columns kept in the index directory
+type TitleIndex struct {
+	idx  *vellum.FST
+	data *recordStore
+}
+
+// titleOpen opens the files of its index in indexDir, which Open checks
+// against the manifest first
+func titleOpen(indexDir string) (*TitleIndex, error) {
+	idx, err := fstSetFile(path.Join(indexDir, TITLES))
+	if err != nil {
+		return nil, err
+	}
+	data, err := openRecordStore(path.Join(indexDir, TITLERECORDS))
+	if err != nil {
+		idx.Close()
+		return nil, err
+	}
+	return &TitleIndex{idx, data}, nil
+}
+
+// Close releases the FST and the record store
+func (t *TitleIndex) Close() error {
+	return closeAll(t.idx, t.data)
+}
+
+func titleCreate(src *dataSource, indexDir string) (*TitleIndex, error) {
+	t := src.t
+	fstTitleFile := path.Join(indexDir, TITLES)
+	tsv, err := src.open(IMDBBasics)
+	if err != nil {
+		return nil, err
+	}
+	defer tsv.Close()
+
+	records, err := createRecordStore(path.Join(indexDir, TITLERECORDS))
+	if err != nil {
+		return nil, err
+	}
+	defer records.Close()
+
+	titleBuilder, titleIndexFile, err := fstSetBuilderFile(fstTitleFile)
+	if err != nil {
+		return nil, err
+	}
+
+	titles, err := readSortedTitles(tsv, records, t.start(IMDBBasics, StageParse, 0))
+	if err != nil {
+		return nil, TitleError(fmt.Sprintf("failed to read titles tsv: %v", err))
+	}
+	if err = records.Close(); err != nil {
+		return nil, err
+	}
+
+	p := t.start(TITLES, StageInsert, int64(len(titles)))
+	for _, title := range titles {
+		if err = titleBuilder.Insert([]byte(title.Id), title.Offset); err != nil {
+			return nil, fmt.Errorf("failed to insert title into title builder: %w", err)
+		}
+		if err = p.add(1); err != nil {
+			return nil, err
+		}
+	}
+	p.finish()
+
+	if err = titleBuilder.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close title builder: %w", err)
+	}
+	if err = titleIndexFile.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close title index file: %w", err)
+	}
+
+	return titleOpen(indexDir)
+}
+
+// Title returns the title record for the given IMDb identifier
+func (t *TitleIndex) Title(id []uint8) (*types.Title, error) {
+	title, ok, err := t.Lookup(id)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("title %q: %w", id, ErrNotFound)
+	}
+	return title, nil
+}
+
+// Lookup returns the title record for the given id and whether it exists
+func (t *TitleIndex) Lookup(id []uint8) (*types.Title, bool, error) {
+	offset, valid, err := t.idx.Get(id)
+	if err != nil || !valid {
+		return nil, false, err
+	}
+
+	recs, err := t.data.read(offset, 1)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read title %q: %v: %w", id, err, ErrCorruptIndex)
+	}
+
+	title, err := readTitle(recs[0])
+	if err != nil {
+		return nil, false, fmt.Errorf("%v: %w", err, ErrCorruptIndex)
+	}
+	title.Offset = offset
+	return title, true, nil
+}
+
+// readSortedTitles reads the basics TSV, adds every row to records and
+// returns the address of the record of every title
+func readSortedTitles(in io.Reader, records *recordWriter, p *progress) ([]*types.Title, error) {
+	titles := []*types.Title{}
+	header := []string{}
+	csvReader := csvRBuilder(in)
+	for {
+		rec, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read csv: %w", err)
+		}
+
+		if len(header) == 0 {
+			header = rec
+			continue
+		}
+
+		offset, err := records.add(rec)
+		if err != nil {
+			return nil, err
+		}
+		titles = append(titles, &types.Title{Id: rec[0], Offset: offset})
+		if err = p.add(1); err != nil {
+			return nil, err
+		}
+	}
+	p.finish()
+	return titles, nil
+}
+
+func readTitle(rec []string) (*types.Title, error) {
+	if len(rec) != 9 {
+		return nil, TitleError(fmt.Sprintf("expected 9 columns in title record, got %d: %v", len(rec), rec))
+	}
+
+	kind, err := parseTitleKind(rec[1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse kind for %v got %w", rec, err)
+	}
+
+	startYear, err := parseOptionalUint(rec[5])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse start year for %v got %w", rec, err)
+	}
+
+	endYear, err := parseOptionalUint(rec[6])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse end year for %v got %w", rec, err)
+	}
+
+	runtime, err := parseOptionalUint(rec[7])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse runtime for %v got %w", rec, err)
+	}
+
+	return &types.Title{
+		Id:             rec[0],
+		Kind:           kind,
+		Title:          rec[2],
+		OriginalTitle:  rec[3],
+		IsAdult:        rec[4] == "1",
+		StartYear:      startYear,
+		EndYear:        endYear,
+		RuntimeMinutes: runtime,
+		Genres:         optionalString(rec[8]),
+	}, nil
+}
+
+func parseTitleKind(kind string) (types.TitleKind, error) {
+	for _, k := range types.TitleKinds {
+		if string(k) == kind {
+			return k, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %q", ErrorUnknownTitle, kind)
+}
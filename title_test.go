@@ -0,0 +1,70 @@
+package imdb
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/jbpratt78/imdb-index/types"
+)
+
+// index gets setup in episode_test.go:TestMain
+func TestTitleBasic(t *testing.T) {
+	idx, err := titleOpen(tmpDir)
+	if err != nil {
+		t.Fatalf("failed to open title index: %v", err)
+	}
+
+	title, err := idx.Title([]byte("tt0701063"))
+	if err != nil {
+		t.Fatalf("failed to get title: %v", err)
+	}
+
+	want := &types.Title{
+		Id:             "tt0701063",
+		Kind:           types.TVEpisode,
+		Title:          "Bart's Dog Gets an F",
+		OriginalTitle:  "Bart's Dog Gets an F",
+		StartYear:      1991,
+		RuntimeMinutes: 23,
+		Genres:         "Animation,Comedy",
+		Offset:         title.Offset,
+	}
+	if *title != *want {
+		t.Fatalf("incorrect title: got=%+v want=%+v", title, want)
+	}
+}
+
+func TestTitleFirstRecord(t *testing.T) {
+	idx, err := titleOpen(tmpDir)
+	if err != nil {
+		t.Fatalf("failed to open title index: %v", err)
+	}
+
+	title, err := idx.Title([]byte("tt0096697"))
+	if err != nil {
+		t.Fatalf("failed to get title: %v", err)
+	}
+
+	if title.Kind != types.TVSeries || title.Title != "The Simpsons" {
+		t.Fatalf("incorrect title: %+v", title)
+	}
+	if title.EndYear != 0 {
+		t.Fatalf("incorrect end year: %d", title.EndYear)
+	}
+}
+
+func TestTitleMissing(t *testing.T) {
+	idx, err := titleOpen(tmpDir)
+	if err != nil {
+		t.Fatalf("failed to open title index: %v", err)
+	}
+
+	_, err = idx.Title([]byte("tt9999999"))
+	if !errors.Is(err, ErrNotFound) || !strings.Contains(err.Error(), "tt9999999") {
+		t.Fatalf("expected not found error for missing title, got: %v", err)
+	}
+	if title, ok, err := idx.Lookup([]byte("tt9999999")); title != nil || ok || err != nil {
+		t.Fatalf("expected a missing title to be no error: %v %v", ok, err)
+	}
+}
@@ -0,0 +1,223 @@
+package types
+
+import "encoding/json"
+
+// TitleKind is the kind of titles available
+type TitleKind string
+
+const (
+	Movie        TitleKind = "movie"
+	Short                  = "short"
+	TVEpisode              = "tvEpisode"
+	TVMiniSeries           = "tvMiniSeries"
+	TVMovie                = "tvMovie"
+	TVPilot                = "tvPilot"
+	TVSeries               = "tvSeries"
+	TVShort                = "tvShort"
+	TVSpecial              = "tvSpecial"
+	Video                  = "video"
+	VideoGame              = "videoGame"
+)
+
+// TitleKinds is every kind of title available
+var TitleKinds = []TitleKind{
+	Movie, Short, TVEpisode, TVMiniSeries, TVMovie, TVPilot, TVSeries,
+	TVShort, TVSpecial, Video, VideoGame,
+}
+
+// Query is for searching records
+type Query struct {
+	// The name to search for.
+	Name string
+	// The name of the scorer ranking name matches. Empty for the default.
+	NameScorer string
+	// The name of the similarity function re-ranking name matches. Empty for
+	// no re-ranking.
+	Similarity string
+	// The maximum number of results to return.
+	Size uint
+	// The kinds of titles to return. Empty for every kind.
+	Kinds []TitleKind
+	// The range of start years of the titles to return.
+	Year Range
+	// The range of votes of the titles to return.
+	Votes Range
+	// The range of seasons of the episodes to return.
+	Season Range
+	// The range of episode numbers of the episodes to return.
+	Episode Range
+	// The IMDb identifier of the TV show whose episodes to return.
+	TvShowID string
+}
+
+// NewQuery returns a query for the given name with the default size
+func NewQuery(name string) *Query {
+	return &Query{Name: name, Size: 30}
+}
+
+// Range is an inclusive range of numbers. A nil bound is unbounded.
+type Range struct {
+	Start *uint32
+	End   *uint32
+}
+
+// Contains reports whether n is within the range
+func (r Range) Contains(n uint32) bool {
+	if r.Start != nil && n < *r.Start {
+		return false
+	}
+	if r.End != nil && n > *r.End {
+		return false
+	}
+	return true
+}
+
+// IsUnbounded reports whether the range contains every number
+func (r Range) IsUnbounded() bool {
+	return r.Start == nil && r.End == nil
+}
+
+// Title is An IMDb title record.
+//
+// This is the primary type of an IMDb media entry. This record defines the
+// identifier of an IMDb title, which serves as a foreign key in other data
+// files (such as alternate names, episodes and ratings).
+type Title struct {
+	// An IMDb identifier.
+	//
+	// Generally, this is a fixed width string beginning with the characters
+	// `tt`.
+	Id string `json:"id"`
+	// The specific type of a title, e.g., movie, TV show, episode, etc.
+	Kind TitleKind `json:"kind"`
+	// The primary name of this title.
+	Title string `json:"title"`
+	// The "original" name of this title.
+	OriginalTitle string `json:"original_title"`
+	// Whether this title is classified as "adult" material or not.
+	IsAdult bool `json:"is_adult"`
+	// The start year of this title.
+	//
+	// Generally, things like movies or TV episodes have a start year to
+	// indicate their release year and no end year. TV shows also have a start
+	// year. TV shows that are still airing lack an end time, but TV shows
+	// that have stopped will typically have an end year indicating when it
+	// stopped airing.
+	//
+	// Note that not all titles have a start year.
+	StartYear uint32 `json:"start_year"`
+	// The end year of this title.
+	//
+	// This is typically used to indicate the ending year of a TV show that
+	// has stopped production.
+	EndYear uint32 `json:"end_year"`
+	// The runtime, in minutes, of this title.
+	RuntimeMinutes uint32 `json:"runtime_minutes"`
+	// A comma separated string of genres.
+	Genres string `json:"genres"`
+	Offset uint64 `json:"-"`
+}
+
+// Aka is a single alternate name.
+//
+// Every title has one or more names, and zero or more alternate names. To
+// represent multiple names, AKA or "also known as" records are provided.
+// There may be many AKA records for a single title.
+type Aka struct {
+	// The IMDb identifier that these AKA records describe.
+	Id string `json:"id"`
+	// The order in which an AKA record should be preferred.
+	Order int32 `json:"order"`
+	// The alternate name.
+	Title string `json:"title"`
+	// A geographic region in which this alternate name applies.
+	Region string `json:"region"`
+	// The language of this alternate name.
+	Language string `json:"language"`
+	// A comma separated list of types for this name.
+	Types string `json:"types"`
+	// A comma separated list of attributes for this name.
+	Attributes      string `json:"attributes"`
+	IsOriginalTitle bool   `json:"is_original_title"`
+	Offset          uint64 `json:"-"`
+	Count           uint64 `json:"-"`
+}
+
+// Episode is a single episode record.
+//
+// An episode record is an entry that joins two title records together, and
+// provides episode specific information, such as the season and episode
+// number. The two title records joined correspond to the title record for the
+// TV show and the title record for the episode.
+type Episode struct {
+	// The IMDb title identifier for this episode.
+	Id string `json:"id"`
+	// The IMDb title identifier for the parent TV show of this episode.
+	TvShowID string `json:"tv_show_id"`
+	// The season in which this episode is contained, or UnknownNumber. Season
+	// 0 holds the specials of a show.
+	Season uint32 `json:"season"`
+	// The episode number of the season in which this episode is contained, or
+	// UnknownNumber.
+	Episode uint32 `json:"episode"`
+}
+
+// UnknownNumber is the season or episode number of an episode IMDb does not
+// number, `\N` in the dataset. It is null in JSON.
+const UnknownNumber = ^uint32(0)
+
+// episodeJSON is an Episode with its unknown numbers as null
+type episodeJSON struct {
+	Id       string  `json:"id"`
+	TvShowID string  `json:"tv_show_id"`
+	Season   *uint32 `json:"season"`
+	Episode  *uint32 `json:"episode"`
+}
+
+func knownOrNil(n uint32) *uint32 {
+	if n == UnknownNumber {
+		return nil
+	}
+	return &n
+}
+
+func nilOrUnknown(n *uint32) uint32 {
+	if n == nil {
+		return UnknownNumber
+	}
+	return *n
+}
+
+func (e Episode) MarshalJSON() ([]byte, error) {
+	return json.Marshal(episodeJSON{e.Id, e.TvShowID, knownOrNil(e.Season), knownOrNil(e.Episode)})
+}
+
+func (e *Episode) UnmarshalJSON(data []byte) error {
+	var v episodeJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*e = Episode{v.Id, v.TvShowID, nilOrUnknown(v.Season), nilOrUnknown(v.Episode)}
+	return nil
+}
+
+// IsSpecial reports whether the episode is one of the specials of season 0
+func (e *Episode) IsSpecial() bool {
+	return e.Season == 0
+}
+
+// IsNumbered reports whether both the season and episode number are known
+func (e *Episode) IsNumbered() bool {
+	return e.Season != UnknownNumber && e.Episode != UnknownNumber
+}
+
+// A rating associated with a single title record.
+type Rating struct {
+	// The IMDb title identifier for this rating.
+	Id string `json:"id"`
+	// The rating, on a scale of 0 to 10, for this title.
+	Rating float32 `json:"rating"`
+	// The number of votes involved in this rating.
+	Votes  uint32 `json:"votes"`
+	Offset uint64 `json:"-"`
+}
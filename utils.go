@@ -1,25 +1,15 @@
-package main
+package imdb
 
 import (
-	"bufio"
-	"compress/gzip"
-	"context"
 	"encoding/csv"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
-	"path"
-	"sort"
-	"strings"
+	"strconv"
 
 	"github.com/couchbase/vellum"
-	"golang.org/x/exp/mmap"
-	"golang.org/x/sync/errgroup"
 )
 
-const IMDBBaseURL = "https://datasets.imdbws.com"
-
 // IMDBBasics is the TSV file in the IMDb dataset that defines the canonical
 // set of titles available to us. Each record contains basic information about
 // a title, such as its IMDb identifier (e.g., `tt0096697`), primary title,
@@ -54,104 +44,6 @@ var (
 	ErrorUnknownDirective  = fmt.Errorf("unrecognized search directive")
 )
 
-// DownloadAll concurrently downloads all of the imdb datasets and writes them to disk
-func DownloadAll(dir string) error {
-	dataSets := []string{
-		"title.akas.tsv.gz",
-		"title.basics.tsv.gz",
-		"title.episode.tsv.gz",
-		"title.ratings.tsv.gz",
-	}
-
-	// make dir
-	if err := os.Mkdir(path.Join(dir, "data"), os.ModePerm); err != nil {
-		return err
-	}
-
-	errs, _ := errgroup.WithContext(context.Background())
-	for _, set := range dataSets {
-		set := set
-		errs.Go(func() error {
-			return download(set, dir)
-		})
-	}
-
-	if err := errs.Wait(); err != nil {
-		return err
-	}
-	return nil
-}
-
-// Downloads a single data set, decompresses it and writes it to the
-// corresponding file path in the given directory.
-func download(file, outdir string) error {
-	outfile := path.Join(outdir, "data", strings.TrimSuffix(file, path.Ext(file)))
-	f, err := os.OpenFile(outfile, os.O_RDWR|os.O_CREATE, os.ModePerm)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	resp, err := http.Get(fmt.Sprintf("%s/%s", IMDBBaseURL, file))
-	if err != nil {
-		return err
-	}
-
-	r, err := gzip.NewReader(resp.Body)
-	if err != nil {
-		return err
-	}
-	defer r.Close()
-
-	// sort and write
-	if err = writeSortedCSVRecords(r, f); err != nil {
-		return err
-	}
-	return nil
-}
-
-// Read all CSV data into memory and sort the records in lexicographic order.
-//
-// This is unfortunately necessary because the IMDb data is no longer sorted
-// in lexicographic order with respect to the `tt` identifiers. This appears
-// to be fallout as a result of adding 10 character identifiers (previously,
-// only 9 character identifiers were used).
-func writeSortedCSVRecords(in io.Reader, out io.Writer) error {
-	// We actually only sort the raw lines here instead of parsing CSV records,
-	// since parsing into CSV records has fairly substantial memory overhead.
-	// Since IMDb CSV data never contains a record that spans multiple lines,
-	// this transformation is okay.
-	var data []string
-	scanner := bufio.NewScanner(in)
-	for scanner.Scan() {
-		data = append(data, scanner.Text())
-	}
-
-	if err := scanner.Err(); err != nil {
-		return err
-	}
-
-	sort.Strings(data[1:])
-
-	w := bufio.NewWriter(out)
-	prev := ""
-	for i, d := range data {
-		first := strings.Split(d, "\t")[0]
-		if i > 0 && first == prev {
-			continue
-		}
-
-		prev = first
-		fmt.Fprintln(w, d)
-	}
-
-	if err := w.Flush(); err != nil {
-		return err
-	}
-
-	return nil
-}
-
 // FstSetFile opens an FST set file for the given path as a memory map
 func fstSetFile(path string) (*vellum.FST, error) {
 	set, err := vellum.Open(path)
@@ -170,6 +62,8 @@ func fstSetBuilderFile(path string) (*vellum.Builder, io.ReadCloser, error) {
 
 	set, err := vellum.New(file, nil)
 	if err != nil {
+		file.Close()
+		os.Remove(path)
 		return nil, nil, err
 	}
 	return set, file, nil
@@ -183,10 +77,57 @@ func csvRBuilder(in io.Reader) *csv.Reader {
 	return csvReader
 }
 
-func mmapReader(path string) (*io.SectionReader, error) {
-	m, err := mmap.Open(path)
+// parseOptionalUint parses an unsigned integer column where IMDb's `\N`
+// marker for a missing value is mapped to zero
+func parseOptionalUint(s string) (uint32, error) {
+	if s == `\N` {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(s, 10, 32)
 	if err != nil {
-		return nil, err
+		return 0, err
+	}
+	return uint32(n), nil
+}
+
+// optionalString maps IMDb's `\N` marker for a missing value to the empty
+// string
+func optionalString(s string) string {
+	if s == `\N` {
+		return ""
+	}
+	return s
+}
+
+// exactBounds returns the FST iterator bounds covering the keys made of id,
+// the nul delimiter and anything after it, but not those of longer ids that
+// share id as a prefix
+func exactBounds(id []byte) ([]byte, []byte) {
+	lower := append(append([]byte{}, id...), 0x00)
+	upper := append(append([]byte{}, id...), 0x01)
+	return lower, upper
+}
+
+// prefixBounds returns the bounds of the keys starting with prefix, where a
+// nil upper bound is unbounded
+func prefixBounds(prefix []byte) ([]byte, []byte) {
+	upper := append([]byte{}, prefix...)
+	for i := len(upper) - 1; i >= 0; i-- {
+		if upper[i] < 0xFF {
+			upper[i]++
+			return prefix, upper[:i+1]
+		}
+	}
+	return prefix, nil
+}
+
+// closeAll closes every closer and returns the first error
+func closeAll(closers ...io.Closer) error {
+	var first error
+	for _, c := range closers {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
 	}
-	return io.NewSectionReader(m, 0, int64(m.Len())), nil
+	return first
 }
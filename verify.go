@@ -0,0 +1,467 @@
+package imdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+
+	"github.com/couchbase/vellum"
+)
+
+// Problem is an inconsistency found by Verify
+type Problem struct {
+	// The index file the problem was found in.
+	File string `json:"file"`
+	// The key or identifier concerned, if any.
+	Key     string `json:"key,omitempty"`
+	Message string `json:"message"`
+}
+
+func (p Problem) String() string {
+	if p.Key == "" {
+		return fmt.Sprintf("%s: %s", p.File, p.Message)
+	}
+	return fmt.Sprintf("%s: %q: %s", p.File, p.Key, p.Message)
+}
+
+// indexFiles are the files of a complete index
+var indexFiles = []string{
+	TITLES, TITLERECORDS, AKAS, AKARECORDS, SEASONS, TVSHOWS, RATINGS,
+	RATINGSRANK, NAMES, NAMESPOSTINGS, NAMESDOCS, NAMESCONFIG,
+}
+
+// Verify checks the index in indexDir and returns every inconsistency found.
+//
+// The files are compared with the manifest, then every FST is walked: its
+// keys are decoded and must be in order, the offsets of the title and akas
+// indices must point at records of the same identifier in the record
+// stores, the tvshows and seasons FSTs must hold the same episodes and the
+// postings of the name index must point at its documents. Unlike Open,
+// Verify goes on past the first problem. The error is only for failures to
+// carry out the checks, such as ctx being done.
+func Verify(ctx context.Context, indexDir string) ([]Problem, error) {
+	indexDir, err := resolveIndexDir(indexDir)
+	if err != nil {
+		return nil, err
+	}
+	v := &verifier{ctx: ctx, dir: indexDir}
+	v.checkManifest()
+
+	titles, err := titleOpen(indexDir)
+	if err != nil {
+		v.report(TITLES, "", "failed to open: %v", err)
+	} else {
+		defer titles.Close()
+		v.checkTitles(titles)
+	}
+	if akas, err := akasOpen(indexDir); err != nil {
+		v.report(AKAS, "", "failed to open: %v", err)
+	} else {
+		defer akas.Close()
+		v.checkAkas(akas)
+	}
+	if episodes, err := episodeOpen(indexDir); err != nil {
+		v.report(SEASONS, "", "failed to open: %v", err)
+	} else {
+		defer episodes.Close()
+		v.checkEpisodes(episodes)
+	}
+	if ratings, err := ratingsOpen(indexDir); err != nil {
+		v.report(RATINGS, "", "failed to open: %v", err)
+	} else {
+		defer ratings.Close()
+		v.checkRatings(ratings)
+	}
+	if names, err := nameOpen(indexDir); err != nil {
+		v.report(NAMES, "", "failed to open: %v", err)
+	} else {
+		defer names.Close()
+		v.checkNames(names)
+	}
+
+	if v.err != nil {
+		return nil, v.err
+	}
+	return v.problems, nil
+}
+
+type verifier struct {
+	ctx      context.Context
+	dir      string
+	manifest *Manifest
+	problems []Problem
+	// the first failure to carry out a check, after which checks stop
+	err error
+}
+
+func (v *verifier) report(file, key, format string, args ...interface{}) {
+	v.problems = append(v.problems, Problem{File: file, Key: key, Message: fmt.Sprintf(format, args...)})
+}
+
+// records compares the number of records of a file with the manifest
+func (v *verifier) records(file string, n int64) {
+	if v.manifest == nil {
+		return
+	}
+	if info, ok := v.manifest.Files[file]; ok && info.Records != n {
+		v.report(file, "", "has %d records, the manifest records %d", n, info.Records)
+	}
+}
+
+// checkManifest compares the size and checksum of every file with the
+// manifest
+func (v *verifier) checkManifest() {
+	data, err := ioutil.ReadFile(path.Join(v.dir, MANIFEST))
+	if err != nil {
+		v.report(MANIFEST, "", "failed to read: %v", err)
+		return
+	}
+	var m Manifest
+	if err = json.Unmarshal(data, &m); err != nil {
+		v.report(MANIFEST, "", "failed to decode: %v", err)
+		return
+	}
+	v.manifest = &m
+	if m.FormatVersion != IndexFormatVersion {
+		v.report(MANIFEST, "", "format version %d, but version %d is required", m.FormatVersion, IndexFormatVersion)
+	}
+
+	for _, name := range indexFiles {
+		if _, ok := m.Files[name]; !ok {
+			v.report(MANIFEST, name, "file is not listed")
+		}
+	}
+	names := make([]string, 0, len(m.Files))
+	for name := range m.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if v.ctx.Err() != nil {
+			v.err = v.ctx.Err()
+			return
+		}
+		info := m.Files[name]
+		sum, size, err := hashFile(path.Join(v.dir, name))
+		if os.IsNotExist(err) {
+			v.report(name, "", "file is missing")
+			continue
+		}
+		if err != nil {
+			v.report(name, "", "failed to read: %v", err)
+			continue
+		}
+		if size != info.Size {
+			v.report(name, "", "has %d bytes, the manifest records %d", size, info.Size)
+		} else if sum != info.SHA256 {
+			v.report(name, "", "checksum %s does not match the manifest's %s", sum, info.SHA256)
+		}
+	}
+}
+
+// walk calls fn with every key and value of an FST, reporting keys out of
+// order. The key is only valid during the call.
+func (v *verifier) walk(file string, fst *vellum.FST, fn func(key []byte, val uint64)) {
+	if v.err != nil {
+		return
+	}
+	itr, err := fst.Iterator(nil, nil)
+	var prev []byte
+	n := 0
+	for err == nil {
+		key, val := itr.Current()
+		if prev != nil && bytes.Compare(prev, key) >= 0 {
+			v.report(file, string(key), "key is not after the previous key %q", prev)
+		}
+		prev = append(prev[:0], key...)
+		fn(key, val)
+
+		if n++; n%progressInterval == 0 {
+			if v.err = v.ctx.Err(); v.err != nil {
+				return
+			}
+		}
+		err = itr.Next()
+	}
+	if !errors.Is(err, vellum.ErrIteratorDone) {
+		v.report(file, "", "failed to iterate: %v", err)
+	}
+}
+
+// splitKey checks that a key holds an identifier, a NUL and at least fixed
+// more bytes, and returns the identifier
+func splitKey(key []byte, fixed int) (string, error) {
+	nul := bytes.IndexByte(key, 0x00)
+	if nul < 1 {
+		return "", fmt.Errorf("key has no identifier")
+	}
+	if len(key) < nul+1+fixed {
+		return "", fmt.Errorf("key is %d bytes, at least %d expected", len(key), nul+1+fixed)
+	}
+	return string(key[:nul]), nil
+}
+
+func (v *verifier) checkTitles(titles *TitleIndex) {
+	var prev int64 = -1
+	v.walk(TITLES, titles.idx, func(key []byte, offset uint64) {
+		id := string(key)
+		if int64(offset) <= prev {
+			v.report(TITLES, id, "address %x is not after the previous address %x", offset, prev)
+		}
+		prev = int64(offset)
+
+		recs, err := titles.data.read(offset, 1)
+		if err != nil {
+			v.report(TITLERECORDS, id, "failed to read record: %v", err)
+			return
+		}
+		title, err := readTitle(recs[0])
+		if err != nil {
+			v.report(TITLERECORDS, id, "failed to parse record at address %x: %v", offset, err)
+			return
+		}
+		if title.Id != id {
+			v.report(TITLES, id, "address %x points at the record of %q", offset, title.Id)
+		}
+	})
+	v.records(TITLES, int64(titles.idx.Len()))
+	v.records(TITLERECORDS, titles.data.records)
+	if n := int64(titles.idx.Len()); n != titles.data.records {
+		v.report(TITLERECORDS, "", "has %d records for %d titles", titles.data.records, n)
+	}
+}
+
+func (v *verifier) checkAkas(akas *AkasIndex) {
+	var prev int64 = -1
+	var rows int64
+	v.walk(AKAS, akas.idx, func(key []byte, val uint64) {
+		id := string(key)
+		count := int(val >> 48)
+		offset := int64(val & ((1 << 48) - 1))
+		rows += int64(count)
+		if offset <= prev {
+			v.report(AKAS, id, "address %x is not after the previous address %x", offset, prev)
+		}
+		prev = offset
+		if count == 0 {
+			v.report(AKAS, id, "has no records")
+			return
+		}
+
+		recs, err := akas.data.read(uint64(offset), count)
+		if err != nil {
+			v.report(AKARECORDS, id, "failed to read %d records: %v", count, err)
+			return
+		}
+		for i, rec := range recs {
+			aka, err := readAka(rec)
+			if err != nil {
+				v.report(AKARECORDS, id, "failed to parse record %d of %d: %v", i+1, count, err)
+				return
+			}
+			if aka.Id != id {
+				v.report(AKAS, id, "record %d of %d at address %x belongs to %q", i+1, count, offset, aka.Id)
+				return
+			}
+		}
+	})
+	v.records(AKAS, int64(akas.idx.Len()))
+	v.records(AKARECORDS, akas.data.records)
+	if rows != akas.data.records {
+		v.report(AKARECORDS, "", "has %d records, the akas index counts %d", akas.data.records, rows)
+	}
+}
+
+// episodeKey is an episode as found in one of the episode FSTs, to compare
+// the contents of both
+type episodeKey struct {
+	id, show        string
+	season, episode uint32
+}
+
+func (a episodeKey) less(b episodeKey) bool {
+	if a.id != b.id {
+		return a.id < b.id
+	}
+	if a.show != b.show {
+		return a.show < b.show
+	}
+	if a.season != b.season {
+		return a.season < b.season
+	}
+	return a.episode < b.episode
+}
+
+func (v *verifier) checkEpisodes(episodes *EpisodeIndex) {
+	var seasons, shows []episodeKey
+
+	v.walk(SEASONS, episodes.seasons, func(key []byte, _ uint64) {
+		if _, err := splitKey(key, 9); err != nil {
+			v.report(SEASONS, string(key), "%v", err)
+			return
+		}
+		ep := readEpisode(key)
+		seasons = append(seasons, episodeKey{ep.Id, ep.TvShowID, ep.Season, ep.Episode})
+	})
+
+	var prev string
+	v.walk(TVSHOWS, episodes.tvshows, func(key []byte, _ uint64) {
+		id, err := splitKey(key, 9)
+		if err != nil {
+			v.report(TVSHOWS, string(key), "%v", err)
+			return
+		}
+		ep := readTvshow(key)
+		if id == prev {
+			v.report(TVSHOWS, id, "episode belongs to several shows")
+		}
+		prev = id
+		shows = append(shows, episodeKey{ep.Id, ep.TvShowID, ep.Season, ep.Episode})
+	})
+	if v.err != nil {
+		return
+	}
+	v.records(SEASONS, int64(episodes.seasons.Len()))
+	v.records(TVSHOWS, int64(episodes.tvshows.Len()))
+
+	// both FSTs must hold the same episodes, so compare them in one order
+	sort.Slice(seasons, func(i, j int) bool { return seasons[i].less(seasons[j]) })
+	sort.Slice(shows, func(i, j int) bool { return shows[i].less(shows[j]) })
+	for i, j := 0, 0; i < len(seasons) || j < len(shows); {
+		switch {
+		case j == len(shows) || (i < len(seasons) && seasons[i].less(shows[j])):
+			v.report(TVSHOWS, seasons[i].id, "episode %d of season %d of %q is missing", seasons[i].episode, seasons[i].season, seasons[i].show)
+			i++
+		case i == len(seasons) || shows[j].less(seasons[i]):
+			v.report(SEASONS, shows[j].id, "episode %d of season %d of %q is missing", shows[j].episode, shows[j].season, shows[j].show)
+			j++
+		default:
+			i++
+			j++
+		}
+	}
+}
+
+func (v *verifier) checkRatings(ratings *RatingsIndex) {
+	var prev string
+	var prevOffset int64 = -1
+	v.walk(RATINGS, ratings.idx, func(key []byte, offset uint64) {
+		id, err := splitKey(key, 8)
+		if err != nil {
+			v.report(RATINGS, string(key), "%v", err)
+			return
+		}
+		if len(key) != len(id)+9 {
+			v.report(RATINGS, id, "key is %d bytes, %d expected", len(key), len(id)+9)
+		}
+		r := readRating(key)
+		if id == prev {
+			v.report(RATINGS, id, "title has several ratings")
+		}
+		prev = id
+		if int64(offset) <= prevOffset {
+			v.report(RATINGS, id, "offset %d is not after the previous offset %d", offset, prevOffset)
+		}
+		prevOffset = int64(offset)
+		if math.IsNaN(float64(r.Rating)) || r.Rating < 0 || r.Rating > 10 {
+			v.report(RATINGS, id, "rating %s is out of range", strconv.FormatFloat(float64(r.Rating), 'f', -1, 32))
+		}
+	})
+	v.records(RATINGS, int64(ratings.idx.Len()))
+
+	// the rank FST holds the same ratings, each at its offset
+	v.walk(RATINGSRANK, ratings.rank, func(key []byte, offset uint64) {
+		if len(key) < 9 {
+			v.report(RATINGSRANK, string(key), "key is %d bytes, at least 9 expected", len(key))
+			return
+		}
+		r := readRatingRank(key)
+		rt, ok, err := ratings.Lookup([]byte(r.Id))
+		switch {
+		case err != nil:
+			v.report(RATINGSRANK, r.Id, "failed to look up rating: %v", err)
+		case !ok:
+			v.report(RATINGSRANK, r.Id, "rating is missing from %s", RATINGS)
+		case rt.Rating != r.Rating || rt.Votes != r.Votes:
+			v.report(RATINGSRANK, r.Id, "rating %v with %d votes, %s has %v with %d votes", r.Rating, r.Votes, RATINGS, rt.Rating, rt.Votes)
+		default:
+			// both keys are made of the same id, rating and votes
+			key, _ := writeRating(rt)
+			if o, _, _ := ratings.idx.Get(key); o != offset {
+				v.report(RATINGSRANK, r.Id, "offset %d, %s has %d", offset, RATINGS, o)
+			}
+		}
+	})
+	if v.err == nil && ratings.rank.Len() != ratings.idx.Len() {
+		v.report(RATINGSRANK, "", "has %d ratings, %s has %d", ratings.rank.Len(), RATINGS, ratings.idx.Len())
+	}
+	v.records(RATINGSRANK, int64(ratings.rank.Len()))
+}
+
+func (v *verifier) checkNames(names *NameIndex) {
+	var end int64
+	size := int64(names.postings.Len())
+	buf := make([]byte, 4)
+	v.walk(NAMES, names.idx, func(key []byte, val uint64) {
+		gram := string(key)
+		offset := int64(val)
+		// postings are written one after the other in the order of the ngrams
+		if offset != end {
+			v.report(NAMES, gram, "postings at offset %d, %d expected", offset, end)
+		}
+		if offset+4 > size {
+			v.report(NAMES, gram, "offset %d is past the end of %s", offset, NAMESPOSTINGS)
+			end = size
+			return
+		}
+		names.postings.ReadAt(buf, offset)
+		count := int64(binary.BigEndian.Uint32(buf))
+		end = offset + 4 + 8*count
+		if end > size {
+			v.report(NAMESPOSTINGS, gram, "%d postings run past the end of the file", count)
+			end = size
+			return
+		}
+
+		ps, err := names.postingsFor(gram)
+		if err != nil {
+			v.report(NAMESPOSTINGS, gram, "%v", err)
+			return
+		}
+		for i, p := range ps {
+			if uint64(p.doc) >= names.numDocs {
+				v.report(NAMESPOSTINGS, gram, "document %d out of range", p.doc)
+			}
+			if i > 0 && p.doc <= ps[i-1].doc {
+				v.report(NAMESPOSTINGS, gram, "document %d follows document %d", p.doc, ps[i-1].doc)
+			}
+			if p.freq == 0 {
+				v.report(NAMESPOSTINGS, gram, "document %d has a zero frequency", p.doc)
+			}
+		}
+	})
+	if v.err == nil && end != size {
+		v.report(NAMESPOSTINGS, "", "%d bytes follow the last postings", size-end)
+	}
+	v.records(NAMES, int64(names.idx.Len()))
+	v.records(NAMESPOSTINGS, int64(names.idx.Len()))
+	v.records(NAMESDOCS, int64(names.numDocs))
+
+	for id := uint64(0); id < names.numDocs && v.err == nil; id++ {
+		if _, err := names.doc(uint32(id)); err != nil {
+			v.report(NAMESDOCS, strconv.FormatUint(id, 10), "failed to read document: %v", err)
+		}
+		if id%progressInterval == 0 {
+			v.err = v.ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package imdb
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/couchbase/vellum"
+)
+
+func TestVerify(t *testing.T) {
+	problems, err := Verify(context.Background(), tmpDir)
+	if err != nil {
+		t.Fatalf("failed to verify index: %v", err)
+	}
+	if len(problems) != 0 {
+		t.Fatalf("expected no problems, got: %v", problems)
+	}
+}
+
+func TestVerifyRecords(t *testing.T) {
+	dir, cleanup := copyIndex(t)
+	defer cleanup()
+	name := path.Join(dir, TITLERECORDS)
+	store, err := openRecordStore(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recs, err := store.read(0, int(store.records))
+	store.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// rename the first title without changing the length of its id
+	recs[0][0] = "zz" + recs[0][0][2:]
+	w, err := createRecordStore(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range recs {
+		if _, err = w.add(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err := Verify(context.Background(), dir)
+	if err != nil {
+		t.Fatalf("failed to verify index: %v", err)
+	}
+	found := map[string]bool{}
+	for _, p := range problems {
+		found[p.File] = true
+		if p.File == TITLES && !strings.Contains(p.Message, "points at the record of") {
+			t.Fatalf("unexpected problem: %v", p)
+		}
+	}
+	if !found[TITLERECORDS] || !found[TITLES] {
+		t.Fatalf("expected the checksum and the record to be reported, got: %v", problems)
+	}
+}
+
+func TestVerifyEpisodes(t *testing.T) {
+	dir, cleanup := copyIndex(t)
+	defer cleanup()
+
+	// drop the first episode of the seasons FST
+	fst, err := vellum.Open(path.Join(dir, SEASONS))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys [][]byte
+	itr, err := fst.Iterator(nil, nil)
+	for err == nil {
+		key, _ := itr.Current()
+		keys = append(keys, append([]byte{}, key...))
+		err = itr.Next()
+	}
+	fst.Close()
+	if !errors.Is(err, vellum.ErrIteratorDone) {
+		t.Fatal(err)
+	}
+
+	f, err := os.Create(path.Join(dir, SEASONS))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := vellum.New(f, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range keys[1:] {
+		if err = b.Insert(key, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	problems, err := Verify(context.Background(), dir)
+	if err != nil {
+		t.Fatalf("failed to verify index: %v", err)
+	}
+	missing := readEpisode(keys[0])
+	var found bool
+	for _, p := range problems {
+		if p.File == SEASONS && p.Key == missing.Id && strings.Contains(p.Message, "is missing") {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected episode %s to be reported missing, got: %v", missing.Id, problems)
+	}
+}
+
+func TestVerifyMissing(t *testing.T) {
+	dir, cleanup := copyIndex(t)
+	defer cleanup()
+	os.Remove(path.Join(dir, RATINGS))
+
+	problems, err := Verify(context.Background(), dir)
+	if err != nil {
+		t.Fatalf("failed to verify index: %v", err)
+	}
+	var found bool
+	for _, p := range problems {
+		if p.File == RATINGS {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected the missing ratings to be reported, got: %v", problems)
+	}
+}
+
+func TestVerifyCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Verify(ctx, tmpDir); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected cancellation, got: %v", err)
+	}
+}